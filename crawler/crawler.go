@@ -1,77 +1,671 @@
 package crawler
 
 import (
+	"context"
+	"errors"
 	"io"
 	"net/url"
 	"sync"
 	"time"
 
 	"github.com/jarifibrahim/webcrawler/fetchers"
-	"github.com/jarifibrahim/webcrawler/tree"
+	"github.com/jarifibrahim/webcrawler/graph"
+	"github.com/jarifibrahim/webcrawler/sitemap"
 	log "github.com/sirupsen/logrus"
 )
 
-var wg sync.WaitGroup
+const (
+	// defaultMaxDepth is the depth used when WithMaxDepth is not provided.
+	defaultMaxDepth = 3
+	// defaultConcurrency is the number of workers used when WithConcurrency
+	// is not provided.
+	defaultConcurrency = 10
+	// defaultClusterDepth is the number of path segments the graph nodes are
+	// clustered by when WithClusterDepth is not provided.
+	defaultClusterDepth = 1
+	// defaultMaxRedirectHops is the number of redirects a URL may go through
+	// before its chain is reported as too long when WithMaxRedirectHops is
+	// not provided.
+	defaultMaxRedirectHops = 1
+)
+
+// Crawler crawls a website starting from the base URL. Every Crawler has its
+// own state, so multiple crawlers can run concurrently in the same process.
+// A single Crawler must not be Run concurrently.
+type Crawler struct {
+	baseURL       string
+	maxDepth      int
+	concurrency   int
+	breadthFirst  bool
+	checkLinks    bool // checkLinks checks the status of the links which are not crawled
+	checkExternal bool // checkExternal checks the links to other domains when checkLinks is set
+	fetcher       fetchers.Fetcher
+	extractor     fetchers.LinksExtractor
+	treeWriter    io.Writer // the tree is generated only if treeWriter is set
+	siteMapWriter io.Writer
+	siteMapConfig sitemap.Config
+	siteMapFiles  *sitemap.FileOptions // siteMapFiles is set if the sitemap is split into files
+	records       *recordWriter        // records is set if a JSON record is written for every crawled URL
+	pagesCSV      io.Writer
+	edgesCSV      io.Writer
+	dotWriter     io.Writer
+	graphMLWriter io.Writer
+	clusterDepth  int      // clusterDepth is the number of path segments the graph nodes are clustered by
+	expectedURLs  []string // expectedURLs are checked for orphan pages if set
+	rankOptions   graph.RankOptions
+	scoresJSON    io.Writer
+
+	maxRedirectHops int // maxRedirectHops is the number of hops above which a redirect chain is too long
+
+	state *CrawlerState
+}
+
+// Option configures a Crawler.
+type Option func(*Crawler)
+
+// WithBaseURL sets the starting URL for the crawler.
+func WithBaseURL(baseURL string) Option {
+	return func(c *Crawler) {
+		c.baseURL = baseURL
+	}
+}
+
+// WithMaxDepth sets the maximum number of links followed from the base URL.
+func WithMaxDepth(maxDepth int) Option {
+	return func(c *Crawler) {
+		c.maxDepth = maxDepth
+	}
+}
+
+// WithConcurrency sets the number of workers fetching pages in parallel.
+// It bounds the number of concurrent requests made to the target site.
+func WithConcurrency(n int) Option {
+	return func(c *Crawler) {
+		if n < 1 {
+			n = 1
+		}
+		c.concurrency = n
+	}
+}
+
+// WithFetcher sets the fetcher used to fetch the pages. A SimpleFetcher is
+// used by default.
+func WithFetcher(fetcher fetchers.Fetcher) Option {
+	return func(c *Crawler) {
+		c.fetcher = fetcher
+	}
+}
+
+// WithLinksExtractor sets the extractor used to find links on a page.
+// fetchers.SimpleLinkExtractor is used by default.
+func WithLinksExtractor(extractor fetchers.LinksExtractor) Option {
+	return func(c *Crawler) {
+		c.extractor = extractor
+	}
+}
+
+// WithTreeWriter enables the generation of the URL tree. The tree is the
+// breadth first spanning tree of the link graph (see
+// graph.Graph.SpanningTree). It is written to w once the crawl is complete.
+func WithTreeWriter(w io.Writer) Option {
+	return func(c *Crawler) {
+		c.treeWriter = w
+	}
+}
+
+// WithSiteMapWriter sets the writer the xml sitemap is written to once the
+// crawl is complete.
+func WithSiteMapWriter(w io.Writer) Option {
+	return func(c *Crawler) {
+		c.siteMapWriter = w
+	}
+}
+
+// WithSiteMapFiles writes the sitemap to a directory once the crawl is
+// complete. The sitemap is split into files within the protocol limits and
+// a sitemap index referencing them is written as well.
+func WithSiteMapFiles(opts sitemap.FileOptions) Option {
+	return func(c *Crawler) {
+		c.siteMapFiles = &opts
+	}
+}
+
+// WithSiteMapConfig sets how the optional fields of the sitemap entries are
+// derived. By default only <loc> and <lastmod> are written.
+func WithSiteMapConfig(config sitemap.Config) Option {
+	return func(c *Crawler) {
+		c.siteMapConfig = config
+	}
+}
+
+// WithJSONLWriter writes a JSON record (see Record) to w for every crawled
+// URL, one per line. The records are written as soon as the URLs are
+// crawled, so an interrupted crawl still leaves the records of the URLs
+// crawled so far.
+func WithJSONLWriter(w io.Writer) Option {
+	return func(c *Crawler) {
+		c.records = newRecordWriter(w)
+	}
+}
+
+// WithPagesCSVWriter sets the writer the CSV table of the pages is written
+// to once the crawl is complete. See CrawlerState.WritePagesCSV
+func WithPagesCSVWriter(w io.Writer) Option {
+	return func(c *Crawler) {
+		c.pagesCSV = w
+	}
+}
+
+// WithEdgesCSVWriter sets the writer the CSV table of the links between the
+// pages is written to once the crawl is complete. See
+// CrawlerState.WriteEdgesCSV
+func WithEdgesCSVWriter(w io.Writer) Option {
+	return func(c *Crawler) {
+		c.edgesCSV = w
+	}
+}
+
+// WithDOTWriter sets the writer the link graph is written to in the
+// Graphviz DOT format once the crawl is complete.
+func WithDOTWriter(w io.Writer) Option {
+	return func(c *Crawler) {
+		c.dotWriter = w
+	}
+}
+
+// WithGraphMLWriter sets the writer the link graph is written to in the
+// GraphML format once the crawl is complete.
+func WithGraphMLWriter(w io.Writer) Option {
+	return func(c *Crawler) {
+		c.graphMLWriter = w
+	}
+}
+
+// WithClusterDepth sets the number of path segments the nodes of the link
+// graph are clustered by. See graph.Cluster. The nodes are clustered by host
+// if n is 0 and not clustered if n is negative. Defaults to 1.
+func WithClusterDepth(n int) Option {
+	return func(c *Crawler) {
+		c.clusterDepth = n
+	}
+}
+
+// WithRankOptions sets how the PageRank and the hub and authority scores of
+// the pages are computed. The defaults of the graph package are used for the
+// missing options.
+func WithRankOptions(opts graph.RankOptions) Option {
+	return func(c *Crawler) {
+		c.rankOptions = opts
+	}
+}
+
+// WithScoresJSONWriter sets the writer the scores of the pages (see
+// PageScore) are written to as JSON once the crawl is complete.
+func WithScoresJSONWriter(w io.Writer) Option {
+	return func(c *Crawler) {
+		c.scoresJSON = w
+	}
+}
+
+// WithMaxRedirectHops sets the number of redirects a URL may go through
+// before its chain is reported as too long in Result.Redirects. Defaults to
+// 1, so a redirect to a URL which redirects again is reported. The length
+// of the chains isn't checked if n is 0.
+func WithMaxRedirectHops(n int) Option {
+	return func(c *Crawler) {
+		c.maxRedirectHops = n
+	}
+}
+
+// WithOrphanCheck enables the orphan page check. The expected URLs (eg. the
+// URLs listed in the sitemap of the site) which are not reached through
+// links are reported in Result.Orphans.
+func WithOrphanCheck(expected []string) Option {
+	return func(c *Crawler) {
+		c.expectedURLs = expected
+	}
+}
+
+// WithBreadthFirst crawls the pages level by level. Every page is recorded at
+// its shortest click depth and the result is deterministic across runs.
+// By default the links are followed as soon as a page is fetched, so a page
+// may be recorded through a longer path.
+func WithBreadthFirst() Option {
+	return func(c *Crawler) {
+		c.breadthFirst = true
+	}
+}
+
+// WithLinkCheck enables the broken link checker. The links which are not
+// crawled because of the max depth are checked with a HEAD request (if the
+// fetcher implements fetchers.LinkChecker). The links to other domains are
+// checked the same way if checkExternal is true. They are never crawled.
+func WithLinkCheck(checkExternal bool) Option {
+	return func(c *Crawler) {
+		c.checkLinks = true
+		c.checkExternal = checkExternal
+	}
+}
+
+// New returns a new Crawler configured with the given options.
+func New(opts ...Option) *Crawler {
+	c := &Crawler{
+		maxDepth:        defaultMaxDepth,
+		concurrency:     defaultConcurrency,
+		clusterDepth:    defaultClusterDepth,
+		maxRedirectHops: defaultMaxRedirectHops,
+		extractor:       fetchers.SimpleLinkExtractor,
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	if c.fetcher == nil {
+		c.fetcher = fetchers.NewSimpleFetcher(c.baseURL)
+	}
+	return c
+}
+
+// Result contains the summary of a crawl.
+type Result struct {
+	SeenURLs    int           // SeenURLs is the number of unique URLs found
+	CrawledURLs int           // CrawledURLs is the number of URLs fetched
+	Duration    time.Duration // Duration is the total time taken by the crawl
+	Errors      []error       // Errors contains the errors occurred while fetching pages
+	// RobotsSkipped contains the URLs which were not fetched because
+	// robots.txt disallows them
+	RobotsSkipped []string
+	// Pages contains the result of fetching every crawled URL
+	Pages map[string]*fetchers.PageResult
+	// CheckedURLs is the number of URLs checked by the link checker
+	CheckedURLs int
+	// BrokenLinks contains the URLs which returned a 4xx/5xx status or
+	// couldn't be fetched, along with the pages linking to them
+	BrokenLinks []BrokenLink
+	// HreflangIssues contains the hreflang alternates without a return link
+	HreflangIssues []HreflangIssue
+	// Redirects contains the URLs which redirect along with their redirect
+	// chain
+	Redirects []RedirectChain
+	// Graph contains every link found on the crawled pages
+	Graph *graph.Graph
+	// InboundLinks contains the number of links to every URL seen
+	InboundLinks map[string]int
+	// Orphans contains the expected URLs which were not reached through
+	// links. Only set if the orphan check is enabled
+	Orphans []string
+	// Scores contains the internal link equity of the pages of the site.
+	// Only set if an output uses the scores: the scores JSON, the pages CSV
+	// or the PageRank priorities of the sitemap
+	Scores []PageScore
+}
+
+// ErrMissingBaseURL is returned by Run when the crawler has no base URL.
+var ErrMissingBaseURL = errors.New("crawler: base URL is not set")
+
+// Run crawls the base URL using a pool of workers. The sitemap and the tree
+// (if enabled) are written once the crawl is complete.
+//
+// When ctx is done no new pages are fetched and in-flight requests are
+// aborted. The sitemap and the tree are still written from the pages crawled
+// so far, and the partial result is returned along with ctx.Err().
+func (c *Crawler) Run(ctx context.Context) (*Result, error) {
+	if c.baseURL == "" {
+		return nil, ErrMissingBaseURL
+	}
+	start := time.Now()
+	c.state = NewCrawlerState()
+	c.crawlAll(ctx)
+
+	result := &Result{
+		SeenURLs:    c.state.seenURLCount,
+		CrawledURLs: c.state.crawledURLCount,
+		Duration:    time.Since(start),
+		Errors:      c.state.errors,
+
+		RobotsSkipped:  c.state.robotsSkipped,
+		Pages:          c.state.pages,
+		CheckedURLs:    c.state.checkedURLCount,
+		BrokenLinks:    c.state.BrokenLinks(),
+		HreflangIssues: c.state.HreflangIssues(),
+		Redirects:      c.state.RedirectChains(c.maxRedirectHops),
+		Graph:          c.state.Graph(),
+		InboundLinks:   c.state.InboundLinks(),
+	}
+	if c.expectedURLs != nil {
+		result.Orphans = c.state.Orphans(c.expectedURLs)
+	}
+	if c.needsScores() {
+		result.Scores = c.state.ComputeScores(c.internal, c.rankOptions)
+	}
+	log.Info("Total URLs found:", result.SeenURLs)
+	log.Info("Total URLs crawled:", result.CrawledURLs)
+	log.Info("Total URLs disallowed by robots.txt:", len(result.RobotsSkipped))
+	if c.checkLinks {
+		log.Info("Total URLs checked:", result.CheckedURLs)
+	}
+	log.Info("Total broken links:", len(result.BrokenLinks))
+	log.Info("Total non-reciprocal hreflang links:", len(result.HreflangIssues))
+	log.Info("Total redirects:", len(result.Redirects))
+	if c.expectedURLs != nil {
+		log.Info("Total orphan pages:", len(result.Orphans))
+	}
+	log.Info("Total time taken:", result.Duration)
+
+	c.writeOutputs(result)
+	if err := ctx.Err(); err != nil {
+		log.Warn("Crawl stopped before completion: ", err)
+		return result, err
+	}
+	return result, nil
+}
+
+// internal checks if the url is part of the crawled site
+func (c *Crawler) internal(url string) bool {
+	return isPartOfDomain(c.baseURL, url)
+}
+
+// needsScores checks if the scores of the pages should be computed
+func (c *Crawler) needsScores() bool {
+	return c.scoresJSON != nil || c.pagesCSV != nil || c.siteMapConfig.Priority == sitemap.PriorityPageRank
+}
+
+// writeOutputs writes the sitemap, the CSV tables, the scores, the link
+// graph and the tree which are enabled. The errors are logged.
+func (c *Crawler) writeOutputs(result *Result) {
+	if c.siteMapWriter != nil {
+		c.state.WriteSiteMap(c.siteMapWriter, c.siteMapConfig, c.internal)
+	}
+	if c.siteMapFiles != nil {
+		if _, err := sitemap.WriteFiles(c.state.SiteMapURLs(c.siteMapConfig, c.internal), *c.siteMapFiles); err != nil {
+			log.Error(err)
+		}
+	}
+	if c.pagesCSV != nil {
+		if err := c.state.WritePagesCSV(c.pagesCSV); err != nil {
+			log.Error(err)
+		}
+	}
+	if c.edgesCSV != nil {
+		if err := c.state.WriteEdgesCSV(c.edgesCSV); err != nil {
+			log.Error(err)
+		}
+	}
+	if c.scoresJSON != nil {
+		if err := WriteScoresJSON(c.scoresJSON, result.Scores); err != nil {
+			log.Error(err)
+		}
+	}
+	if c.dotWriter != nil {
+		if err := c.state.Graph().WriteDOT(c.dotWriter, c.clusterDepth); err != nil {
+			log.Error(err)
+		}
+	}
+	if c.graphMLWriter != nil {
+		if err := c.state.Graph().WriteGraphML(c.graphMLWriter, c.clusterDepth); err != nil {
+			log.Error(err)
+		}
+	}
+
+	if c.treeWriter != nil {
+		c.state.Graph().SpanningTree(c.baseURL).WriteTree(c.treeWriter)
+	}
+}
+
+// crawlAll crawls the base URL and all the URLs reachable from it up to
+// maxDepth. Returns once there are no URLs left to crawl.
+func (c *Crawler) crawlAll(ctx context.Context) {
+	c.state.AddURL(c.baseURL, 0)
+	if c.maxDepth < 1 {
+		log.WithField("base_url", c.baseURL).Info("Max depth reached. Skipping")
+		return
+	}
+	start := task{url: c.baseURL, depth: c.maxDepth}
+	if c.breadthFirst {
+		c.crawlLevels(ctx, start)
+	} else {
+		c.crawlFrontier(ctx, start)
+	}
+}
+
+// crawlFrontier queues the URLs in a frontier which is drained by the
+// workers. The new URLs are queued as soon as a page is fetched.
+func (c *Crawler) crawlFrontier(ctx context.Context, start task) {
+	f := newFrontier()
+	f.push(start)
+
+	var wg sync.WaitGroup
+	for i := 0; i < c.concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for t, ok := f.pop(); ok; t, ok = f.pop() {
+				if links, ok := c.fetch(ctx, &t); ok {
+					c.follow(t, links, f.push)
+				}
+				f.done()
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+// crawlLevels crawls the URLs level by level. All the pages of a level are
+// fetched by the workers before the links found on them are followed, so
+// every URL is recorded at its shortest click depth. The links are followed
+// in the order the pages were queued, which makes the result deterministic.
+func (c *Crawler) crawlLevels(ctx context.Context, start task) {
+	level := []task{start}
+	for len(level) > 0 && ctx.Err() == nil {
+		log.WithField("urls", len(level)).Infof("Started crawling level %d", c.clickDepth(level[0]))
+
+		type fetched struct {
+			links []fetchers.Link
+			ok    bool
+		}
+		results := make([]fetched, len(level))
+		jobs := make(chan int)
+		var wg sync.WaitGroup
+		for i := 0; i < c.concurrency; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for j := range jobs {
+					results[j].links, results[j].ok = c.fetch(ctx, &level[j])
+				}
+			}()
+		}
+		for j := range level {
+			jobs <- j
+		}
+		close(jobs)
+		wg.Wait()
+
+		var next []task
+		push := func(t task) {
+			next = append(next, t)
+		}
+		for j, t := range level {
+			if results[j].ok {
+				c.follow(t, results[j].links, push)
+			}
+		}
+		level = next
+	}
+}
+
+// clickDepth returns the number of links between the base URL and the URL
+// of the task
+func (c *Crawler) clickDepth(t task) int {
+	return c.maxDepth - t.depth
+}
 
 /*
-crawl fetches the list of URLs using the fetcher and crawls the new list of
-URLs in a depth-first-search manner. Returns the list of URLs crawled.
+fetch fetches the list of links on the page using the fetcher. If t is a
+link check, only the status of the URL is checked.
+If the URL redirects, the final URL is the canonical page: t is replaced by
+the task of the final URL and its links are only returned for the first URL
+redirecting to it.
+Returns false if the page couldn't be fetched or if t is a link check.
 Params:
-	baseURL - The URL to crawl
-	fetcher - A fetcher used to fetch the list of URLs to crawl next
-	urlNode - urlNode is used to build the tree when --show-tree flag is set.
-      	      urlNode stores a tree of link. Where root of the tree is the base URL and
-			  all the links reachable from root are stored at it's children
-	state   - state stores the global state of crawling. It contains the set of URLs
-		      already crawled.
+	ctx - No new pages are fetched once ctx is done
+	t   - The URL to crawl
 */
-func crawl(baseURL string, depth int, fetcher fetchers.Fetcher, urlNode *tree.URLNode, state *CrawlerState) {
+func (c *Crawler) fetch(ctx context.Context, t *task) ([]fetchers.Link, bool) {
 	contextLogger := log.WithFields(log.Fields{
-		"base_url": baseURL,
-		"depth":    depth,
+		"base_url": t.url,
+		"depth":    t.depth,
 	})
 
-	defer wg.Done()
-
-	// state.AddURL() returns false if the URL was already seen.
-	if !state.AddURL(baseURL) {
-		contextLogger.Info("URL already crawled. Skipping")
-		return
+	if ctx.Err() != nil {
+		contextLogger.Info("Crawl stopped. Skipping")
+		return nil, false
 	}
 
-	if depth < 1 {
-		contextLogger.Info("Max depth reached. Skipping")
-		return
+	if t.checkOnly {
+		return nil, c.check(ctx, *t)
 	}
 
 	contextLogger.Infof("Started crawling page")
 	defer contextLogger.Info("Finished crawling page")
 
 	// Get list of URLs on the given page
-	urlList, err := fetcher.Fetch(baseURL, fetchers.SimpleLinkExtractor)
+	page := c.fetcher.Fetch(ctx, t.url, c.extractor)
+	if page.Err != nil && ctx.Err() != nil {
+		// The request was aborted because the crawl was stopped
+		contextLogger.Info("Crawl stopped. Fetch aborted")
+		return nil, false
+	}
+	if errors.Is(page.Err, fetchers.ErrDisallowedByRobots) {
+		contextLogger.Info("URL disallowed by robots.txt. Skipping")
+		c.state.AddRobotsSkipped(t.url)
+		c.record(*t, page)
+		return nil, false
+	}
 
-	state.IncrementCrawledCount()
+	c.state.IncrementCrawledCount()
+	if page.Err == nil && isRedirected(t.url, page) {
+		internal := isPartOfDomain(t.url, fetchers.NormalizeURL(page.FinalURL))
+		if !internal {
+			page.Links = nil
+		}
+		final, ok := c.redirect(*t, page)
+		if !ok || !internal {
+			contextLogger.WithField("final_url", final.url).Info("Final URL not part of the domain or already seen. Skipping")
+			return nil, false
+		}
+		*t = final
+	} else {
+		c.state.SetPage(t.url, page)
+		c.record(*t, page)
+	}
 
-	if err != nil {
-		contextLogger.Infof("failed to fetch URL")
-		return
+	if page.Err != nil {
+		contextLogger.WithField("attempts", page.Attempts).Errorf("Failed to fetch URL: %s", page.Err)
+		c.state.AddError(page.Err)
+		return nil, false
+	}
+	if !page.IsSuccess() {
+		contextLogger.WithField("status", page.StatusCode).Info("URL returned an error status")
 	}
+	return page.Links, true
+}
 
-	for _, url := range urlList {
-		// Add new URL as child of the current node.
-		childNode := urlNode.AddChild(url)
+// check checks the status of the URL of t and stores the result.
+// Always returns false since the links of the page are not followed.
+func (c *Crawler) check(ctx context.Context, t task) bool {
+	contextLogger := log.WithField("url", t.url)
+	contextLogger.Info("Checking link")
 
-		if !isPartOfDomain(baseURL, url) {
-			// even if we're not crawling the URL, mark it as seen
-			state.AddURL(url)
-			contextLogger.WithField("child_url", url).Info("Child URL not part of the domain. Skipping.")
+	var page *fetchers.PageResult
+	if checker, ok := c.fetcher.(fetchers.LinkChecker); ok {
+		page = checker.Check(ctx, t.url)
+	} else {
+		page = c.fetcher.Fetch(ctx, t.url, c.extractor)
+		page.Links = nil
+	}
+	switch {
+	case page.Err != nil && ctx.Err() != nil:
+		contextLogger.Info("Crawl stopped. Check aborted")
+	case errors.Is(page.Err, fetchers.ErrDisallowedByRobots):
+		contextLogger.Info("URL disallowed by robots.txt. Skipping")
+		c.state.AddRobotsSkipped(t.url)
+		c.record(t, page)
+	case page.Err == nil && isRedirected(t.url, page):
+		c.state.IncrementCheckedCount()
+		c.redirect(t, page)
+	default:
+		c.state.IncrementCheckedCount()
+		c.state.SetPage(t.url, page)
+		c.record(t, page)
+	}
+	return false
+}
+
+// redirect stores the page fetched for t, which was reached at another URL.
+// See CrawlerState.AddRedirect. Returns the task of the final URL and false
+// if the final URL was already seen.
+func (c *Crawler) redirect(t task, page *fetchers.PageResult) (task, bool) {
+	final := task{url: fetchers.NormalizeURL(page.FinalURL), parent: t.url, depth: t.depth, checkOnly: t.checkOnly}
+	log.WithFields(log.Fields{"url": t.url, "final_url": final.url}).Info("URL redirected")
+	added := c.state.AddRedirect(t.url, final.url, c.clickDepth(t), page)
+	c.record(t, redirectStub(page))
+	if added {
+		c.record(final, page)
+	}
+	return final, added
+}
+
+/*
+follow marks the URLs found on the page of t as seen and pushes the new URLs
+which should be crawled.
+Params:
+	t       - The crawled URL
+	links   - The links found on the page
+	push    - push queues a new URL to crawl
+*/
+func (c *Crawler) follow(t task, links []fetchers.Link, push func(task)) {
+	contextLogger := log.WithFields(log.Fields{
+		"base_url": t.url,
+		"depth":    t.depth,
+	})
+	for _, link := range links {
+		url := link.URL
+		childLogger := contextLogger.WithField("child_url", url)
+		// The link is recorded in the graph even if the URL was already seen.
+		c.state.AddReferrer(url, Referrer{Page: t.url, Text: link.Text})
+
+		// state.AddURL() returns false if the URL was already seen.
+		// The URL is marked as seen even if we're not crawling it.
+		if !c.state.AddURL(url, c.clickDepth(t)+1) {
+			childLogger.Info("URL already seen. Skipping")
 			continue
 		}
-		wg.Add(1)
-		go crawl(url, depth-1, fetcher, childNode, state)
+		internal := isPartOfDomain(t.url, url)
+		if !internal || t.depth-1 < 1 {
+			if internal {
+				childLogger.Info("Max depth reached. Skipping")
+			} else {
+				childLogger.Info("Child URL not part of the domain. Skipping.")
+			}
+			if c.shouldCheck(url, internal) {
+				push(task{url: url, parent: t.url, depth: t.depth - 1, checkOnly: true})
+			}
+			continue
+		}
+		push(task{url: url, parent: t.url, depth: t.depth - 1})
 	}
 }
 
+// shouldCheck checks if the link checker should check a URL which is not
+// crawled. Only http and https URLs are checked.
+func (c *Crawler) shouldCheck(rawURL string, internal bool) bool {
+	if !c.checkLinks || (!internal && !c.checkExternal) {
+		return false
+	}
+	u, err := url.Parse(rawURL)
+	return err == nil && (u.Scheme == "http" || u.Scheme == "https")
+}
+
 // isPartOfDomain checks if the baseURL and urlToCheck belong to the same domain
 func isPartOfDomain(baseURL, urlToCheck string) bool {
 	base, err := url.Parse(baseURL)
@@ -87,37 +681,3 @@ func isPartOfDomain(baseURL, urlToCheck string) bool {
 	return base.Host == testURL.Host
 
 }
-
-// StartCrawling is the main entry point for crawling. It crawls the given URL
-// in depth first search manner.
-//
-// params:
-//	BaseURL: 		It is the starting URL for the crawler
-// 	MaxDepth: 		It determines the depth of depth-first-search
-// 	ShowTree: 		It determines if the tree should be generated for the crawled pages
-//  TreeWriter:		If showTree is true, the tree is written to treeWriter
-// 	SiteMapWriter:	The xml sitemap is written to the sitemapwriter
-func StartCrawling(baseURL string, maxDepth int, showTree bool, treeWriter, siteMapWriter io.Writer) {
-	start := time.Now()
-	var root *tree.URLNode
-	if showTree {
-		root = tree.NewNode(baseURL)
-	}
-
-	crawlerState := NewCrawlerState()
-
-	wg.Add(1)
-	go crawl(baseURL, maxDepth, fetchers.NewSimpleFetcher(baseURL), root, crawlerState)
-	wg.Wait()
-
-	log.Info("Total URLs found:", crawlerState.seenURLCount)
-	log.Info("Total URLs crawled:", crawlerState.crawledURLCount)
-	log.Info("Total time taken:", time.Since(start))
-
-	crawlerState.WriteSiteMap(siteMapWriter)
-
-	if showTree {
-		root.WriteTree(treeWriter)
-	}
-
-}
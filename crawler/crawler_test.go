@@ -2,11 +2,20 @@ package crawler
 
 import (
 	"bytes"
+	"context"
+	"encoding/json"
 	"fmt"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/jarifibrahim/webcrawler/fetchers"
+	"github.com/jarifibrahim/webcrawler/graph"
 
+	"github.com/jarifibrahim/webcrawler/sitemap"
 	"github.com/jarifibrahim/webcrawler/tree"
 	"github.com/sirupsen/logrus"
 	"github.com/stretchr/testify/assert"
@@ -17,29 +26,98 @@ func init() {
 	logrus.SetLevel(logrus.PanicLevel)
 }
 
-func TestStartCrawling(t *testing.T) {
-	baseURL := "http://jarifibrahim.github.io"
+func TestRun(t *testing.T) {
+	baseURL := "https://g.org/"
 	maxDepth := 3
 
-	t.Run("with show tree", func(t *testing.T) {
+	t.Run("with tree", func(t *testing.T) {
 		treeBuffer := bytes.Buffer{}
 		sitemapBuffer := bytes.Buffer{}
-		showTree := true
-		StartCrawling(baseURL, maxDepth, showTree, &treeBuffer, &sitemapBuffer)
+		c := New(WithBaseURL(baseURL), WithMaxDepth(maxDepth), WithFetcher(ffetcher),
+			WithTreeWriter(&treeBuffer), WithSiteMapWriter(&sitemapBuffer))
+		result, err := c.Run(context.Background())
+		assert.Nil(t, err)
+		assert.Equal(t, 8, result.SeenURLs)
+		assert.Equal(t, 8, result.CrawledURLs)
+		// x/tools, net/http and net/html are missing from the fake fetcher
+		assert.Len(t, result.Errors, 3)
+		assert.Len(t, result.Pages, 8)
+		assert.Equal(t, 200, result.Pages["https://g.org/pkg/"].StatusCode)
+		assert.Error(t, result.Pages["https://g.org/x/tools"].Err)
 		// both the buffers should not be empty
 		assert.NotEqual(t, treeBuffer, bytes.Buffer{})
 		assert.NotEqual(t, sitemapBuffer, bytes.Buffer{})
 	})
-	t.Run("without show tree", func(t *testing.T) {
-		treeBuffer := bytes.Buffer{}
+	t.Run("without tree", func(t *testing.T) {
 		sitemapBuffer := bytes.Buffer{}
-		showTree := false
-		StartCrawling(baseURL, maxDepth, showTree, &treeBuffer, &sitemapBuffer)
-		// tree butter should be empty
-		assert.Equal(t, treeBuffer, bytes.Buffer{})
+		c := New(WithBaseURL(baseURL), WithMaxDepth(maxDepth), WithFetcher(ffetcher),
+			WithSiteMapWriter(&sitemapBuffer))
+		_, err := c.Run(context.Background())
+		assert.Nil(t, err)
 		// sitemap buffer should not be empty
 		assert.NotEqual(t, sitemapBuffer, bytes.Buffer{})
-
+	})
+	t.Run("sitemap files", func(t *testing.T) {
+		dir := t.TempDir()
+		c := New(WithBaseURL(baseURL), WithMaxDepth(maxDepth), WithFetcher(ffetcher),
+			WithSiteMapFiles(sitemap.FileOptions{Dir: dir, BaseURL: baseURL, MaxURLs: 2}))
+		result, err := c.Run(context.Background())
+		assert.Nil(t, err)
+		files, err := filepath.Glob(filepath.Join(dir, "sitemap-*.xml"))
+		assert.Nil(t, err)
+		// The pages which couldn't be fetched are not listed
+		assert.Len(t, files, (result.SeenURLs-len(result.Errors)+1)/2)
+		assert.FileExists(t, filepath.Join(dir, sitemap.IndexFileName))
+	})
+	t.Run("graph", func(t *testing.T) {
+		var dot, graphML bytes.Buffer
+		c := New(WithBaseURL(baseURL), WithMaxDepth(maxDepth), WithFetcher(ffetcher),
+			WithDOTWriter(&dot), WithGraphMLWriter(&graphML), WithClusterDepth(-1))
+		_, err := c.Run(context.Background())
+		assert.Nil(t, err)
+		assert.True(t, strings.HasPrefix(dot.String(), "digraph links {"))
+		assert.NotContains(t, dot.String(), "subgraph")
+		assert.Contains(t, dot.String(), `"https://g.org/" -> "https://g.org/pkg/";`)
+		assert.Contains(t, graphML.String(), "<graphml")
+	})
+	t.Run("fetch errors", func(t *testing.T) {
+		c := New(WithBaseURL("https://foo.org/"), WithFetcher(ffetcher))
+		result, err := c.Run(context.Background())
+		assert.Nil(t, err)
+		assert.Len(t, result.Errors, 1)
+	})
+	t.Run("canceled context", func(t *testing.T) {
+		treeBuffer := bytes.Buffer{}
+		sitemapBuffer := bytes.Buffer{}
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		c := New(WithBaseURL(baseURL), WithFetcher(ffetcher),
+			WithTreeWriter(&treeBuffer), WithSiteMapWriter(&sitemapBuffer))
+		result, err := c.Run(ctx)
+		assert.Equal(t, context.Canceled, err)
+		// the partial result only contains the base URL
+		assert.Equal(t, 1, result.SeenURLs)
+		assert.Equal(t, 0, result.CrawledURLs)
+		assert.Empty(t, result.Errors)
+		assert.Equal(t, "https://g.org/\n", treeBuffer.String())
+		assert.Contains(t, sitemapBuffer.String(), "<loc>https://g.org/</loc>")
+	})
+	t.Run("missing base URL", func(t *testing.T) {
+		_, err := New(WithFetcher(ffetcher)).Run(context.Background())
+		assert.Equal(t, ErrMissingBaseURL, err)
+	})
+	t.Run("concurrent crawlers", func(t *testing.T) {
+		var wg sync.WaitGroup
+		for i := 0; i < 5; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				result, err := New(WithBaseURL(baseURL), WithFetcher(ffetcher)).Run(context.Background())
+				assert.Nil(t, err)
+				assert.Equal(t, 8, result.SeenURLs)
+			}()
+		}
+		wg.Wait()
 	})
 }
 func TestWriteSiteMap(t *testing.T) {
@@ -47,9 +125,7 @@ func TestWriteSiteMap(t *testing.T) {
 	state := NewCrawlerState()
 	state.urls = []string{"/foo", "/bar", "/helloWorld"}
 	expectedOutput := `<?xml version="1.0" encoding="UTF-8"?>
-
-<urlset xmlns="http://www.sitemaps.org/schemas/sitemap/0.9">
-
+<urlset xmlns="http://www.sitemaps.org/schemas/sitemap/0.9" xmlns:image="http://www.google.com/schemas/sitemap-image/1.1" xmlns:video="http://www.google.com/schemas/sitemap-video/1.1" xmlns:xhtml="http://www.w3.org/1999/xhtml">
   <url>
     <loc>/foo</loc>
   </url>
@@ -62,10 +138,289 @@ func TestWriteSiteMap(t *testing.T) {
 </urlset>
 `
 	// Write sitemap to writeBuffer
-	state.WriteSiteMap(&writeBuffer)
+	state.WriteSiteMap(&writeBuffer, sitemap.Config{}, allInternal)
 	assert.Equal(t, expectedOutput, writeBuffer.String())
 }
 
+func TestSiteMapURLs(t *testing.T) {
+	lastModified := time.Date(2020, 1, 2, 15, 4, 5, 0, time.UTC)
+	state := NewCrawlerState()
+	state.AddURL("https://g.org/", 0)
+	state.AddURL("https://g.org/blog/", 1)
+	state.AddURL("https://g.org/blog/post", 2)
+	state.AddReferrer("https://g.org/blog/", Referrer{Page: "https://g.org/"})
+	state.AddReferrer("https://g.org/blog/post", Referrer{Page: "https://g.org/"})
+	state.AddReferrer("https://g.org/blog/post", Referrer{Page: "https://g.org/blog/"})
+	state.SetPage("https://g.org/blog/post", &fetchers.PageResult{Document: fetchers.Document{LastModified: lastModified}})
+
+	rule, err := sitemap.ParseChangeFreqRule("/blog/=daily")
+	assert.Nil(t, err)
+
+	t.Run("by depth", func(t *testing.T) {
+		urls := state.SiteMapURLs(sitemap.Config{Priority: sitemap.PriorityDepth, ChangeFreq: []sitemap.ChangeFreqRule{rule}}, gOrgInternal)
+		assert.Equal(t, []sitemap.URL{
+			{Loc: "https://g.org/", Priority: 1},
+			{Loc: "https://g.org/blog/", ChangeFreq: "daily", Priority: 0.8},
+			{Loc: "https://g.org/blog/post", LastMod: lastModified, ChangeFreq: "daily", Priority: 0.6},
+		}, urls)
+	})
+	t.Run("by inbound links", func(t *testing.T) {
+		urls := state.SiteMapURLs(sitemap.Config{Priority: sitemap.PriorityInbound}, gOrgInternal)
+		assert.Equal(t, []sitemap.URL{
+			{Loc: "https://g.org/", Priority: 0.1},
+			{Loc: "https://g.org/blog/", Priority: 0.6},
+			{Loc: "https://g.org/blog/post", LastMod: lastModified, Priority: 1},
+		}, urls)
+	})
+	t.Run("with media", func(t *testing.T) {
+		state.SetPage("https://g.org/", &fetchers.PageResult{Document: fetchers.Document{
+			Title:  "Home",
+			Images: []fetchers.Image{{URL: "https://g.org/logo.png", Alt: "Logo"}},
+			Videos: []fetchers.Video{
+				{URL: "https://g.org/intro.mp4", ThumbnailURL: "https://g.org/intro.jpg"},
+				{URL: "https://g.org/demo.mp4", ThumbnailURL: "https://g.org/demo.jpg", Title: "Demo"},
+			},
+		}})
+		urls := state.SiteMapURLs(sitemap.Config{Images: true, Videos: true}, gOrgInternal)
+		assert.Equal(t, []sitemap.Image{{Loc: "https://g.org/logo.png"}}, urls[0].Images)
+		// The title of the page is used for videos without a title
+		assert.Equal(t, []sitemap.Video{
+			{ThumbnailLoc: "https://g.org/intro.jpg", Title: "Home", ContentLoc: "https://g.org/intro.mp4"},
+			{ThumbnailLoc: "https://g.org/demo.jpg", Title: "Demo", ContentLoc: "https://g.org/demo.mp4"},
+		}, urls[0].Videos)
+
+		urls = state.SiteMapURLs(sitemap.Config{}, gOrgInternal)
+		assert.Nil(t, urls[0].Images)
+		assert.Nil(t, urls[0].Videos)
+	})
+}
+
+func TestSiteMapURLsExclusions(t *testing.T) {
+	state := NewCrawlerState()
+	for _, url := range []string{"https://g.org/", "https://g.org/unfetched", "https://ext.org/", "https://g.org/missing",
+		"https://g.org/error", "https://g.org/failed", "https://g.org/private", "https://g.org/page"} {
+		state.AddURL(url, 1)
+	}
+	state.SetPage("https://g.org/", &fetchers.PageResult{StatusCode: 200})
+	state.SetPage("https://ext.org/", &fetchers.PageResult{StatusCode: 200})
+	state.SetPage("https://g.org/missing", &fetchers.PageResult{StatusCode: 404})
+	state.SetPage("https://g.org/error", &fetchers.PageResult{StatusCode: 503})
+	state.SetPage("https://g.org/failed", &fetchers.PageResult{Err: fmt.Errorf("timeout")})
+	state.AddRobotsSkipped("https://g.org/private")
+	state.SetPage("https://g.org/page", &fetchers.PageResult{StatusCode: 204})
+
+	var locs []string
+	for _, url := range state.SiteMapURLs(sitemap.Config{}, gOrgInternal) {
+		locs = append(locs, url.Loc)
+	}
+	// The other domains, the broken pages and the URLs disallowed by
+	// robots.txt are left out
+	assert.Equal(t, []string{"https://g.org/", "https://g.org/unfetched", "https://g.org/page"}, locs)
+}
+
+func TestWriteCSV(t *testing.T) {
+	state := NewCrawlerState()
+	state.AddURL("https://g.org/", 0)
+	state.AddURL("https://g.org/about", 1)
+	state.AddURL("https://ext.org/", 1)
+	state.AddReferrer("https://g.org/about", Referrer{Page: "https://g.org/", Text: "About"})
+	state.AddReferrer("https://ext.org/", Referrer{Page: "https://g.org/", Text: "Ext"})
+	state.SetPage("https://g.org/", &fetchers.PageResult{StatusCode: 200, Document: fetchers.Document{
+		Title: "Home, sweet home",
+		Links: []fetchers.Link{
+			{URL: "https://g.org/about", Text: "About"},
+			{URL: "https://ext.org/", Text: `Ext "site"`, Rel: "nofollow noopener"},
+		},
+	}})
+	state.SetPage("https://g.org/about", &fetchers.PageResult{StatusCode: 404})
+
+	var buf bytes.Buffer
+	assert.Nil(t, state.WritePagesCSV(&buf))
+	assert.Equal(t, `url,depth,status,title,inbound,outbound,pagerank,hub,authority
+https://g.org/,0,200,"Home, sweet home",0,2,,,
+https://g.org/about,1,404,,1,0,,,
+https://ext.org/,1,,,1,0,,,
+`, buf.String())
+
+	// The scores are only set for the internal URLs
+	state.ComputeScores(func(url string) bool { return isPartOfDomain("https://g.org/", url) }, graph.RankOptions{})
+	buf.Reset()
+	assert.Nil(t, state.WritePagesCSV(&buf))
+	assert.Equal(t, `url,depth,status,title,inbound,outbound,pagerank,hub,authority
+https://g.org/,0,200,"Home, sweet home",0,2,0.350877,1.000000,0.000000
+https://g.org/about,1,404,,1,0,0.649123,0.000000,1.000000
+https://ext.org/,1,,,1,0,,,
+`, buf.String())
+
+	buf.Reset()
+	assert.Nil(t, state.WriteEdgesCSV(&buf))
+	assert.Equal(t, `source,target,anchor,rel,internal
+https://g.org/,https://g.org/about,About,,true
+https://g.org/,https://ext.org/,"Ext ""site""",nofollow noopener,false
+`, buf.String())
+}
+
+func TestGraph(t *testing.T) {
+	state := NewCrawlerState()
+	state.AddURL("https://g.org/", 0)
+	state.AddURL("https://g.org/about", 1)
+	state.AddURL("https://ext.org/", 1)
+	state.AddReferrer("https://g.org/about", Referrer{Page: "https://g.org/", Text: "About"})
+	state.AddReferrer("https://ext.org/", Referrer{Page: "https://g.org/", Text: "Ext"})
+	state.SetPage("https://g.org/", &fetchers.PageResult{StatusCode: 200})
+	state.SetPage("https://g.org/about", &fetchers.PageResult{StatusCode: 404})
+
+	g := state.Graph()
+	assert.Equal(t, []graph.Node{
+		{URL: "https://g.org/", Depth: 0, Status: 200},
+		{URL: "https://g.org/about", Depth: 1, Status: 404},
+		{URL: "https://ext.org/", Depth: 1},
+	}, g.Nodes())
+	assert.Equal(t, []graph.Edge{
+		{Source: "https://g.org/", Target: "https://g.org/about", Text: "About", Internal: true},
+		{Source: "https://g.org/", Target: "https://ext.org/", Text: "Ext"},
+	}, g.Edges())
+}
+
+func TestOrphans(t *testing.T) {
+	state := crawlWith("https://g.org/", 3, ffetcher)
+	expected := []string{
+		"https://g.org/",
+		"https://g.org/pkg/fmt/?lang=en#top",
+		"https://g.org/old/",
+		"https://g.org/pkg/",
+		"https://g.org/old/",
+		"https://g.org/unlisted",
+	}
+	orphans := state.Orphans(expected)
+	assert.Equal(t, []string{"https://g.org/old/", "https://g.org/unlisted"}, orphans)
+
+	inbound := state.InboundLinks()
+	assert.Equal(t, 3, inbound["https://g.org/"])
+	assert.Equal(t, 3, inbound["https://g.org/pkg/"])
+	assert.Equal(t, 1, inbound["https://g.org/net/http"])
+
+	var buf bytes.Buffer
+	assert.Nil(t, WriteOrphans(&buf, orphans))
+	assert.Equal(t, `https://g.org/old/
+https://g.org/unlisted
+
+2 orphan pages found
+`, buf.String())
+
+	t.Run("run", func(t *testing.T) {
+		result, err := New(WithBaseURL("https://g.org/"), WithFetcher(ffetcher), WithOrphanCheck(expected)).Run(context.Background())
+		assert.Nil(t, err)
+		assert.Equal(t, orphans, result.Orphans)
+		assert.Equal(t, inbound, result.InboundLinks)
+
+		result, err = New(WithBaseURL("https://g.org/"), WithFetcher(ffetcher)).Run(context.Background())
+		assert.Nil(t, err)
+		assert.Nil(t, result.Orphans)
+	})
+}
+
+func TestComputeScores(t *testing.T) {
+	state := crawlWith("https://g.org/", 3, ffetcher)
+	state.AddReferrer("https://ext.org/", Referrer{Page: "https://g.org/"})
+	state.AddURL("https://ext.org/", 1)
+	scores := state.ComputeScores(func(url string) bool { return isPartOfDomain("https://g.org/", url) }, graph.RankOptions{})
+
+	// ext.org isn't part of the site
+	assert.Len(t, scores, 8)
+	byURL := make(map[string]PageScore)
+	sum := 0.0
+	for _, score := range scores {
+		byURL[score.URL] = score
+		sum += score.PageRank
+	}
+	assert.InDelta(t, 1, sum, 1e-6)
+	assert.Equal(t, "https://g.org/", scores[0].URL)
+	assert.Equal(t, 3, byURL["https://g.org/"].Inbound)
+	// pkg is linked from the home page and from its children
+	for url, score := range byURL {
+		if url != "https://g.org/pkg/" {
+			assert.Greater(t, byURL["https://g.org/pkg/"].PageRank, score.PageRank, url)
+		}
+	}
+	assert.Equal(t, 1.0, byURL["https://g.org/pkg/"].Hub)
+
+	t.Run("sitemap priority", func(t *testing.T) {
+		urls := state.SiteMapURLs(sitemap.Config{Priority: sitemap.PriorityPageRank}, gOrgInternal)
+		for _, u := range urls {
+			if u.Loc == "https://g.org/pkg/" {
+				assert.Equal(t, 1.0, u.Priority)
+			} else {
+				assert.Less(t, u.Priority, 1.0, u.Loc)
+			}
+		}
+	})
+
+	t.Run("json", func(t *testing.T) {
+		var buf bytes.Buffer
+		assert.Nil(t, WriteScoresJSON(&buf, scores[:1]))
+		var decoded []map[string]interface{}
+		assert.Nil(t, json.Unmarshal(buf.Bytes(), &decoded))
+		assert.Len(t, decoded, 1)
+		assert.Equal(t, "https://g.org/", decoded[0]["url"])
+		assert.Equal(t, 3.0, decoded[0]["inbound"])
+		for _, key := range []string{"pagerank", "hub", "authority"} {
+			assert.Contains(t, decoded[0], key)
+		}
+
+		buf.Reset()
+		assert.Nil(t, WriteScoresJSON(&buf, nil))
+		assert.Equal(t, "[]\n", buf.String())
+	})
+
+	t.Run("run", func(t *testing.T) {
+		var buf bytes.Buffer
+		result, err := New(WithBaseURL("https://g.org/"), WithFetcher(ffetcher), WithScoresJSONWriter(&buf),
+			WithRankOptions(graph.RankOptions{Damping: 0.5})).Run(context.Background())
+		assert.Nil(t, err)
+		assert.Len(t, result.Scores, 8)
+		assert.True(t, strings.HasPrefix(buf.String(), "["))
+
+		result, err = New(WithBaseURL("https://g.org/"), WithFetcher(ffetcher)).Run(context.Background())
+		assert.Nil(t, err)
+		assert.Nil(t, result.Scores)
+	})
+}
+
+func TestHreflangIssues(t *testing.T) {
+	alternates := func(urls ...string) *fetchers.PageResult {
+		page := &fetchers.PageResult{StatusCode: 200}
+		for _, url := range urls {
+			page.Alternates = append(page.Alternates, fetchers.Alternate{URL: url, Hreflang: url[len(url)-3 : len(url)-1]})
+		}
+		return page
+	}
+	state := NewCrawlerState()
+	for _, url := range []string{"https://g.org/en/", "https://g.org/de/", "https://g.org/fr/", "https://g.org/es/"} {
+		state.AddURL(url, 1)
+	}
+	state.SetPage("https://g.org/en/", alternates("https://g.org/en/", "https://g.org/de/", "https://g.org/fr/", "https://g.org/it/"))
+	state.SetPage("https://g.org/de/", alternates("https://g.org/en/", "https://g.org/de/"))
+	// fr doesn't point back to en
+	state.SetPage("https://g.org/fr/", alternates("https://g.org/fr/", "https://g.org/es/"))
+	// es couldn't be fetched
+	state.SetPage("https://g.org/es/", &fetchers.PageResult{StatusCode: 404})
+
+	assert.Equal(t, []HreflangIssue{
+		{Page: "https://g.org/en/", Alternate: "https://g.org/fr/", Hreflang: "fr"},
+	}, state.HreflangIssues())
+
+	var buf bytes.Buffer
+	assert.Nil(t, WriteHreflangIssues(&buf, state.HreflangIssues()))
+	assert.Equal(t, `https://g.org/en/ declares https://g.org/fr/ as "fr" without a return link
+
+1 non-reciprocal hreflang links found
+`, buf.String())
+
+	urls := state.SiteMapURLs(sitemap.Config{Alternates: true}, gOrgInternal)
+	assert.Equal(t, []sitemap.Alternate{{Hreflang: "en", Href: "https://g.org/en/"}, {Hreflang: "de", Href: "https://g.org/de/"}}, urls[1].Alternates)
+}
+
 func TestIsPartOfDomain(t *testing.T) {
 	testData := []struct {
 		name           string
@@ -94,54 +449,37 @@ func TestIsPartOfDomain(t *testing.T) {
 func TestCrawlDepth0(t *testing.T) {
 	expectedURLList := []string{"https://g.org/"}
 	t.Run("without Tree", func(t *testing.T) {
-		state := NewCrawlerState()
-		wg.Add(1)
-		go crawl("https://g.org/", 0, ffetcher, nil, state)
-		wg.Wait()
+		state := crawlWith("https://g.org/", 0, ffetcher)
 		assert.Equal(t, expectedURLList, state.urls)
 	})
 	t.Run("with Tree", func(t *testing.T) {
-		state := NewCrawlerState()
-		rootNode := tree.NewNode("https://g.org/")
-		wg.Add(1)
-		go crawl("https://g.org/", 0, ffetcher, rootNode, state)
-		wg.Wait()
+		state := crawlWith("https://g.org/", 0, ffetcher)
 		assert.Equal(t, expectedURLList, state.urls)
 		// A tree with depth 0 is only the root node
 		expectedTree := tree.NewNode("https://g.org/")
-		assert.Equal(t, expectedTree, rootNode)
+		assert.Equal(t, expectedTree, state.Graph().SpanningTree("https://g.org/"))
 	})
 }
 func TestCrawlDepth1(t *testing.T) {
 	expectedURLList := []string{"https://g.org/", "https://g.org/pkg/", "https://g.org/cmd/"}
 	t.Run("without tree", func(t *testing.T) {
-		state := NewCrawlerState()
-		wg.Add(1)
-		go crawl("https://g.org/", 1, ffetcher, nil, state)
-		wg.Wait()
+		state := crawlWith("https://g.org/", 1, ffetcher)
 		assert.ElementsMatch(t, expectedURLList, state.urls)
 
 	})
 	t.Run("with tree", func(t *testing.T) {
-		state := NewCrawlerState()
-		rootNode := tree.NewNode("https://g.org/")
-		wg.Add(1)
-		go crawl("https://g.org/", 1, ffetcher, rootNode, state)
-		wg.Wait()
+		state := crawlWith("https://g.org/", 1, ffetcher)
 		assert.ElementsMatch(t, expectedURLList, state.urls)
 
 		expectedTree := tree.NewNode("https://g.org/")
 		for _, url := range expectedURLList[1:] {
 			expectedTree.AddChild(url)
 		}
-		assert.Equal(t, expectedTree, rootNode)
+		assert.Equal(t, expectedTree, state.Graph().SpanningTree("https://g.org/"))
 	})
 	t.Run("non existent URL", func(t *testing.T) {
 		t.Run("without tree", func(t *testing.T) {
-			state := NewCrawlerState()
-			wg.Add(1)
-			go crawl("https://foo.org/", 1, ffetcher, nil, state)
-			wg.Wait()
+			state := crawlWith("https://foo.org/", 1, ffetcher)
 			assert.Equal(t, []string{"https://foo.org/"}, state.urls)
 		})
 	})
@@ -153,31 +491,23 @@ func TestCrawlDepth2(t *testing.T) {
 		"https://g.org/net/http", "https://g.org/pkg/fmt/",
 	}
 	t.Run("without tree", func(t *testing.T) {
-		state := NewCrawlerState()
-		wg.Add(1)
-		go crawl("https://g.org/", 2, ffetcher, nil, state)
-		wg.Wait()
+		state := crawlWith("https://g.org/", 2, ffetcher)
 		assert.ElementsMatch(t, expectedURLs, state.urls)
 	})
 	t.Run("with tree", func(t *testing.T) {
-		state := NewCrawlerState()
-		rootNode := tree.NewNode("https://g.org/")
-		wg.Add(1)
-		go crawl("https://g.org/", 2, ffetcher, rootNode, state)
-		wg.Wait()
+		state := crawlWith("https://g.org/", 2, ffetcher)
 		assert.ElementsMatch(t, expectedURLs, state.urls)
 
+		// The links to the URLs already in the tree are only in the graph
 		expectedTree := tree.NewNode("https://g.org/")
 		child := expectedTree.AddChild("https://g.org/pkg/")
-		child.AddChild("https://g.org/")
-		child.AddChild("https://g.org/cmd/")
 		child.AddChild("https://g.org/pkg/fmt/")
 		child.AddChild("https://g.org/pkg/os/")
 		child1 := expectedTree.AddChild("https://g.org/cmd/")
 		child1.AddChild("https://g.org/x/tools")
 		child1.AddChild("https://g.org/net/http")
 		child1.AddChild("https://g.org/net/html")
-		assert.Equal(t, expectedTree, rootNode)
+		assert.Equal(t, expectedTree, state.Graph().SpanningTree("https://g.org/"))
 	})
 }
 func TestCrawlDepth3(t *testing.T) {
@@ -187,25 +517,18 @@ func TestCrawlDepth3(t *testing.T) {
 		"https://g.org/pkg/fmt/", "https://g.org/pkg/os/"}
 
 	t.Run("without tree", func(t *testing.T) {
-		state := NewCrawlerState()
-		wg.Add(1)
-		go crawl("https://g.org/", 3, ffetcher, nil, state)
-		wg.Wait()
+		state := crawlWith("https://g.org/", 3, ffetcher)
 		assert.ElementsMatch(t, depth3ExpectedURLs, state.urls)
 	})
 	t.Run("with tree", func(t *testing.T) {
-		state := NewCrawlerState()
-		rootNode := tree.NewNode("https://g.org/")
-		wg.Add(1)
-		go crawl("https://g.org/", 3, ffetcher, rootNode, state)
-		wg.Wait()
+		state := crawlWith("https://g.org/", 3, ffetcher)
 		assert.ElementsMatch(t, depth3ExpectedURLs, state.urls)
 
 		expectedTree := tree.NewNode("https://g.org/")
 
 		child1 := expectedTree.AddChild("https://g.org/pkg/")
-		child1.AddChild("https://g.org/")
-		child1.AddChild("https://g.org/cmd/")
+		child1.AddChild("https://g.org/pkg/fmt/")
+		child1.AddChild("https://g.org/pkg/os/")
 
 		child2 := expectedTree.AddChild("https://g.org/cmd/")
 
@@ -213,35 +536,25 @@ func TestCrawlDepth3(t *testing.T) {
 		child2.AddChild("https://g.org/net/http")
 		child2.AddChild("https://g.org/net/html")
 
-		child3 := child1.AddChild("https://g.org/pkg/fmt/")
-		child3.AddChild("https://g.org/")
-		child3.AddChild("https://g.org/pkg/")
-
-		child4 := child1.AddChild("https://g.org/pkg/os/")
-		child4.AddChild("https://g.org/")
-		child4.AddChild("https://g.org/pkg/")
+		assert.Equal(t, expectedTree, state.Graph().SpanningTree("https://g.org/"))
 
-		assert.Equal(t, expectedTree, rootNode)
+		// The graph keeps the links to the URLs already seen
+		assert.Len(t, state.Graph().Edges(), 13)
+		assert.ElementsMatch(t, []Referrer{
+			{Page: "https://g.org/pkg/"}, {Page: "https://g.org/pkg/fmt/"}, {Page: "https://g.org/pkg/os/"},
+		}, state.referrers("https://g.org/"))
 
 		// The output of crawledURLs depth 3 onwards should be same since there aren't
 		// anymore URLs below dept 3
 		t.Run("depth 4", func(t *testing.T) {
-			state := NewCrawlerState()
-			rootNode := tree.NewNode("https://g.org/")
-			wg.Add(1)
-			go crawl("https://g.org/", 4, ffetcher, rootNode, state)
-			wg.Wait()
+			state := crawlWith("https://g.org/", 4, ffetcher)
 			assert.ElementsMatch(t, depth3ExpectedURLs, state.urls)
-			assert.Equal(t, expectedTree, rootNode)
+			assert.Equal(t, expectedTree, state.Graph().SpanningTree("https://g.org/"))
 		})
 		t.Run("depth 5", func(t *testing.T) {
-			state := NewCrawlerState()
-			rootNode := tree.NewNode("https://g.org/")
-			wg.Add(1)
-			go crawl("https://g.org/", 5, ffetcher, rootNode, state)
-			wg.Wait()
+			state := crawlWith("https://g.org/", 5, ffetcher)
 			assert.ElementsMatch(t, depth3ExpectedURLs, state.urls)
-			assert.Equal(t, expectedTree, rootNode)
+			assert.Equal(t, expectedTree, state.Graph().SpanningTree("https://g.org/"))
 		})
 	})
 
@@ -290,33 +603,603 @@ func TestActualWebsite(t *testing.T) {
 		"http://jarifibrahim.github.io/tags/javascript/",
 		"http://jarifibrahim.github.io/tags/ui-testing/",
 		"http://jarifibrahim.github.io/tags/async-await/"}
-	state := NewCrawlerState()
-	wg.Add(1)
-	root := tree.NewNode("http://jarifibrahim.github.io")
-	go crawl("http://jarifibrahim.github.io", 3, fetchers.NewSimpleFetcher("http://jarifibrahim.github.io"), root, state)
-	wg.Wait()
+	state := crawlWith("http://jarifibrahim.github.io", 3, fetchers.NewSimpleFetcher("http://jarifibrahim.github.io"))
 	assert.ElementsMatch(t, expectedURLs, state.urls)
 }
 
 func BenchmarkCrawl(b *testing.B) {
 	for i := 0; i < b.N; i++ {
-		state := NewCrawlerState()
-		wg.Add(1)
-		go crawl("http://golang.org/", 4, fetchers.NewSimpleFetcher("http://golang.org/"), nil, state)
-		wg.Wait()
+		crawlWith("http://golang.org/", 4, fetchers.NewSimpleFetcher("http://golang.org/"))
 	}
 
 }
 
+func TestBreadthFirst(t *testing.T) {
+	// "/y" is one click away from "/slow" but three clicks away from "/fast".
+	// "/slow" only returns once its gate is closed by the test.
+	f := &delayFetcher{
+		links: map[string][]string{
+			"https://g.org/":     {"https://g.org/slow", "https://g.org/fast"},
+			"https://g.org/slow": {"https://g.org/y"},
+			"https://g.org/fast": {"https://g.org/x"},
+			"https://g.org/x":    {"https://g.org/y"},
+			"https://g.org/y":    {"https://g.org/z"},
+		},
+	}
+	t.Run("breadth first", func(t *testing.T) {
+		gate := make(chan struct{})
+		f.gates = map[string]chan struct{}{"https://g.org/slow": gate}
+		c := New(WithBaseURL("https://g.org/"), WithMaxDepth(3), WithConcurrency(2),
+			WithBreadthFirst(), WithFetcher(f))
+		c.state = NewCrawlerState()
+		done := make(chan struct{})
+		go func() {
+			c.crawlAll(context.Background())
+			close(done)
+		}()
+		// "/slow" is released once "/fast" is fetched. The links of "/fast"
+		// are only followed once every page of the level is fetched, so "/y"
+		// is still reached through "/slow" first.
+		for c.state.Page("https://g.org/fast") == nil {
+			runtime.Gosched()
+		}
+		close(gate)
+		<-done
+
+		depth, _ := c.state.Depth("https://g.org/y")
+		assert.Equal(t, 2, depth)
+		// "/y" is crawled since it is recorded at depth 2
+		depth, ok := c.state.Depth("https://g.org/z")
+		assert.True(t, ok)
+		assert.Equal(t, 3, depth)
+
+		expectedTree := tree.NewNode("https://g.org/")
+		slow := expectedTree.AddChild("https://g.org/slow")
+		slow.AddChild("https://g.org/y").AddChild("https://g.org/z")
+		fast := expectedTree.AddChild("https://g.org/fast")
+		fast.AddChild("https://g.org/x")
+		assert.Equal(t, expectedTree, c.state.Graph().SpanningTree("https://g.org/"))
+	})
+	t.Run("frontier", func(t *testing.T) {
+		gate := make(chan struct{})
+		f.gates = map[string]chan struct{}{"https://g.org/slow": gate}
+		c := New(WithBaseURL("https://g.org/"), WithMaxDepth(3), WithConcurrency(2), WithFetcher(f))
+		c.state = NewCrawlerState()
+		done := make(chan struct{})
+		go func() {
+			c.crawlAll(context.Background())
+			close(done)
+		}()
+		// "/slow" is released once "/y" is recorded through "/fast" and "/x"
+		for {
+			if _, ok := c.state.Depth("https://g.org/y"); ok {
+				break
+			}
+			runtime.Gosched()
+		}
+		close(gate)
+		<-done
+
+		depth, _ := c.state.Depth("https://g.org/y")
+		assert.Equal(t, 3, depth)
+		_, ok := c.state.Depth("https://g.org/z")
+		assert.False(t, ok)
+	})
+	t.Run("same result as frontier on ffetcher", func(t *testing.T) {
+		for depth := 0; depth <= 4; depth++ {
+			frontierState := crawlWith("https://g.org/", depth, ffetcher)
+
+			c := New(WithBaseURL("https://g.org/"), WithMaxDepth(depth), WithBreadthFirst(), WithFetcher(ffetcher))
+			c.state = NewCrawlerState()
+			c.crawlAll(context.Background())
+
+			assert.ElementsMatch(t, frontierState.urls, c.state.urls)
+			assert.Equal(t, frontierState.Graph().SpanningTree("https://g.org/"), c.state.Graph().SpanningTree("https://g.org/"))
+		}
+	})
+}
+
+// delayFetcher is a Fetcher which returns canned results after a delay. The
+// URLs with a gate are only returned once their gate is closed.
+type delayFetcher struct {
+	links  map[string][]string
+	delays map[string]time.Duration
+	gates  map[string]chan struct{}
+}
+
+func (f *delayFetcher) Fetch(ctx context.Context, url string, noOpExtractor fetchers.LinksExtractor) *fetchers.PageResult {
+	if gate, ok := f.gates[url]; ok {
+		<-gate
+	}
+	time.Sleep(f.delays[url])
+	return &fetchers.PageResult{URL: url, StatusCode: 200, ResponseTime: f.delays[url], Document: fetchers.Document{Links: toLinks(f.links[url])}}
+}
+
+func TestJSONLWriter(t *testing.T) {
+	f := &delayFetcher{
+		links: map[string][]string{
+			"https://g.org/":  {"https://g.org/a", "https://ext.org/"},
+			"https://g.org/a": {"https://g.org/", "https://g.org/b"},
+		},
+		delays: map[string]time.Duration{"https://g.org/a": time.Millisecond},
+	}
+	var buf bytes.Buffer
+	c := New(WithBaseURL("https://g.org/"), WithMaxDepth(2), WithConcurrency(1), WithBreadthFirst(),
+		WithFetcher(f), WithLinkCheck(true), WithJSONLWriter(&buf))
+	_, err := c.Run(context.Background())
+	assert.Nil(t, err)
+
+	// Every record is written on its own line
+	output := buf.String()
+	assert.Equal(t, 4, strings.Count(output, "\n"))
+
+	var records []Record
+	decoder := json.NewDecoder(strings.NewReader(output))
+	for decoder.More() {
+		var record Record
+		assert.Nil(t, decoder.Decode(&record))
+		records = append(records, record)
+	}
+	if !assert.Len(t, records, 4) {
+		return
+	}
+	assert.Equal(t, Record{URL: "https://g.org/", Status: 200, Links: []string{"https://g.org/a", "https://ext.org/"}}, records[0])
+	assert.Equal(t, "https://g.org/a", records[1].URL)
+	assert.Equal(t, "https://g.org/", records[1].Parent)
+	assert.Equal(t, 1, records[1].Depth)
+	assert.Equal(t, float64(1), records[1].ResponseTime)
+	assert.Equal(t, Record{URL: "https://ext.org/", Parent: "https://g.org/", Depth: 1, Status: 200, Links: []string{}, CheckOnly: true}, records[2])
+	assert.Equal(t, Record{URL: "https://g.org/b", Parent: "https://g.org/a", Depth: 2, Status: 200, Links: []string{}, CheckOnly: true}, records[3])
+}
+
+func TestLinkCheck(t *testing.T) {
+	f := &statusFetcher{
+		links: map[string][]fetchers.Link{
+			"https://g.org/": {
+				{URL: "https://g.org/a", Text: "A"},
+				{URL: "https://g.org/missing", Text: "Missing"},
+				{URL: "https://ext.org/gone", Text: "Gone"},
+				{URL: "mailto:me@g.org", Text: "Mail"},
+			},
+			"https://g.org/a": {
+				{URL: "https://g.org/missing", Text: "Missing again"},
+				{URL: "https://g.org/deep", Text: "Deep"},
+				{URL: "https://g.org/deep-broken", Text: "Deep broken"},
+			},
+		},
+		status: map[string]int{
+			"https://g.org/missing":     404,
+			"https://ext.org/gone":      410,
+			"https://g.org/deep-broken": 500,
+		},
+	}
+	t.Run("internal links only", func(t *testing.T) {
+		f.checked = nil
+		c := New(WithBaseURL("https://g.org/"), WithMaxDepth(2), WithBreadthFirst(), WithFetcher(f), WithLinkCheck(false))
+		result, err := c.Run(context.Background())
+		assert.Nil(t, err)
+		// The links at max depth are checked but not crawled
+		assert.ElementsMatch(t, []string{"https://g.org/deep", "https://g.org/deep-broken"}, f.checked)
+		assert.Equal(t, 2, result.CheckedURLs)
+		assert.Equal(t, []BrokenLink{
+			{URL: "https://g.org/missing", StatusCode: 404, Referrers: []Referrer{
+				{Page: "https://g.org/", Text: "Missing"},
+				{Page: "https://g.org/a", Text: "Missing again"},
+			}},
+			{URL: "https://g.org/deep-broken", StatusCode: 500, Referrers: []Referrer{
+				{Page: "https://g.org/a", Text: "Deep broken"},
+			}},
+		}, result.BrokenLinks)
+	})
+	t.Run("external links", func(t *testing.T) {
+		f.checked = nil
+		c := New(WithBaseURL("https://g.org/"), WithMaxDepth(2), WithBreadthFirst(), WithFetcher(f), WithLinkCheck(true))
+		result, err := c.Run(context.Background())
+		assert.Nil(t, err)
+		// mailto links are never checked
+		assert.ElementsMatch(t, []string{"https://ext.org/gone", "https://g.org/deep", "https://g.org/deep-broken"}, f.checked)
+		assert.Len(t, result.BrokenLinks, 3)
+		assert.Equal(t, "https://ext.org/gone", result.BrokenLinks[1].URL)
+	})
+	t.Run("check disabled", func(t *testing.T) {
+		f.checked = nil
+		result, err := New(WithBaseURL("https://g.org/"), WithMaxDepth(2), WithFetcher(f)).Run(context.Background())
+		assert.Nil(t, err)
+		assert.Empty(t, f.checked)
+		assert.Len(t, result.BrokenLinks, 1)
+	})
+}
+
+func TestWriteBrokenLinks(t *testing.T) {
+	var buf bytes.Buffer
+	links := []BrokenLink{
+		{URL: "https://g.org/missing", StatusCode: 404, Referrers: []Referrer{
+			{Page: "https://g.org/", Text: "Missing"},
+			{Page: "https://g.org/a", Text: ""},
+		}},
+		{URL: "https://ext.org/", Err: fmt.Errorf("timeout"), Referrers: []Referrer{
+			{Page: "https://g.org/", Text: "Ext"},
+		}},
+	}
+	assert.Nil(t, WriteBrokenLinks(&buf, links))
+	expected := `404 https://g.org/missing
+	linked from https://g.org/ ("Missing")
+	linked from https://g.org/a ("")
+ERROR https://ext.org/ (timeout)
+	linked from https://g.org/ ("Ext")
+
+2 broken links found
+`
+	assert.Equal(t, expected, buf.String())
+}
+
+// statusFetcher is a Fetcher returning canned links and status codes. It
+// records the URLs checked by the link checker
+type statusFetcher struct {
+	links   map[string][]fetchers.Link
+	status  map[string]int
+	checked []string
+	sync.Mutex
+}
+
+func (f *statusFetcher) result(url string) *fetchers.PageResult {
+	status := 200
+	if s, ok := f.status[url]; ok {
+		status = s
+	}
+	return &fetchers.PageResult{URL: url, StatusCode: status}
+}
+
+func (f *statusFetcher) Fetch(ctx context.Context, url string, noOpExtractor fetchers.LinksExtractor) *fetchers.PageResult {
+	page := f.result(url)
+	page.Links = f.links[url]
+	return page
+}
+
+func (f *statusFetcher) Check(ctx context.Context, url string) *fetchers.PageResult {
+	f.Lock()
+	f.checked = append(f.checked, url)
+	f.Unlock()
+	return f.result(url)
+}
+
+func TestRedirects(t *testing.T) {
+	f := redirectFetcher{
+		"https://g.org/": {StatusCode: 200, Document: fetchers.Document{Links: []fetchers.Link{
+			{URL: "https://g.org/old", Text: "Old"},
+			{URL: "https://g.org/chain", Text: "Chain"},
+			{URL: "https://g.org/loop", Text: "Loop"},
+			{URL: "https://g.org/new", Text: "New"},
+		}}},
+		"https://g.org/old": {FinalURL: "https://g.org/new", StatusCode: 200, Redirects: []fetchers.Redirect{
+			{URL: "https://g.org/old", StatusCode: 301, Location: "/new"},
+		}, Document: fetchers.Document{Links: toLinks([]string{"https://g.org/about"})}},
+		"https://g.org/chain": {FinalURL: "https://g.org/target?utm=1", StatusCode: 200, Redirects: []fetchers.Redirect{
+			{URL: "https://g.org/chain", StatusCode: 302, Location: "/chain2"},
+			{URL: "https://g.org/chain2", StatusCode: 301, Location: "https://g.org/target?utm=1"},
+		}, Document: fetchers.Document{Links: toLinks([]string{"https://g.org/about"})}},
+		"https://g.org/loop": {FinalURL: "https://g.org/loop2", StatusCode: 302, Err: fetchers.ErrRedirectLoop, Redirects: []fetchers.Redirect{
+			{URL: "https://g.org/loop", StatusCode: 302, Location: "/loop2"},
+			{URL: "https://g.org/loop2", StatusCode: 302, Location: "/loop"},
+		}},
+		"https://g.org/new":   {StatusCode: 200, Document: fetchers.Document{Links: toLinks([]string{"https://g.org/about"})}},
+		"https://g.org/about": {StatusCode: 200},
+	}
+	var siteMap bytes.Buffer
+	c := New(WithBaseURL("https://g.org/"), WithMaxDepth(3), WithBreadthFirst(), WithFetcher(f), WithSiteMapWriter(&siteMap))
+	result, err := c.Run(context.Background())
+	assert.Nil(t, err)
+
+	// The final URLs are not crawled again
+	assert.Equal(t, 6, result.CrawledURLs)
+	assert.Equal(t, []RedirectChain{
+		{URL: "https://g.org/old", FinalURL: "https://g.org/new", Hops: f["https://g.org/old"].Redirects,
+			Referrers: []Referrer{{Page: "https://g.org/", Text: "Old"}}},
+		{URL: "https://g.org/chain", FinalURL: "https://g.org/target?utm=1", Hops: f["https://g.org/chain"].Redirects, TooLong: true,
+			Referrers: []Referrer{{Page: "https://g.org/", Text: "Chain"}}},
+		{URL: "https://g.org/loop", FinalURL: "https://g.org/loop2", Hops: f["https://g.org/loop"].Redirects, Loop: true,
+			Referrers: []Referrer{{Page: "https://g.org/", Text: "Loop"}}},
+	}, result.Redirects)
+	// The links of the final page are recorded from the final URL
+	assert.Equal(t, 2, result.InboundLinks["https://g.org/about"])
+	assert.Len(t, result.Graph.InEdges("https://g.org/about"), 2)
+	assert.Equal(t, "https://g.org/target", result.Graph.InEdges("https://g.org/about")[0].Source)
+	assert.Len(t, result.BrokenLinks, 1)
+
+	// The sitemap lists the final URLs instead of the redirecting ones
+	var locs []string
+	for _, url := range c.state.SiteMapURLs(sitemap.Config{}, gOrgInternal) {
+		locs = append(locs, url.Loc)
+	}
+	assert.Equal(t, []string{"https://g.org/", "https://g.org/new", "https://g.org/target", "https://g.org/about"}, locs)
+	assert.NotContains(t, siteMap.String(), "https://g.org/old")
+
+	t.Run("max hops", func(t *testing.T) {
+		c := New(WithBaseURL("https://g.org/"), WithMaxDepth(3), WithFetcher(f), WithMaxRedirectHops(0))
+		result, err := c.Run(context.Background())
+		assert.Nil(t, err)
+		assert.Len(t, result.Redirects, 3)
+		for _, chain := range result.Redirects {
+			assert.False(t, chain.TooLong)
+		}
+	})
+}
+
+func TestRedirectedPages(t *testing.T) {
+	f := redirectFetcher{
+		"https://g.org/": {StatusCode: 200, Document: fetchers.Document{Links: []fetchers.Link{
+			{URL: "https://g.org/en", Text: "English"},
+			{URL: "https://g.org/fr/", Text: "French"},
+		}}},
+		"https://g.org/en": {FinalURL: "https://g.org/en/", StatusCode: 200, Redirects: []fetchers.Redirect{
+			{URL: "https://g.org/en", StatusCode: 301, Location: "/en/"},
+		}, Document: fetchers.Document{
+			Links: []fetchers.Link{{URL: "https://g.org/en/docs", Text: "Docs"}},
+			Alternates: []fetchers.Alternate{
+				{URL: "https://g.org/en/", Hreflang: "en"},
+				{URL: "https://g.org/fr/", Hreflang: "fr"},
+			},
+		}},
+		"https://g.org/fr/": {StatusCode: 200, Document: fetchers.Document{
+			Links: []fetchers.Link{{URL: "https://g.org/en", Text: "English"}},
+			Alternates: []fetchers.Alternate{
+				{URL: "https://g.org/fr/", Hreflang: "fr"},
+				{URL: "https://g.org/en/", Hreflang: "en"},
+			},
+		}},
+		"https://g.org/en/docs": {StatusCode: 200, Document: fetchers.Document{Links: []fetchers.Link{
+			{URL: "https://g.org/old", Text: "Old"},
+		}}},
+		// /old is at max depth, so it is only checked
+		"https://g.org/old": {FinalURL: "https://g.org/new", StatusCode: 200, Redirects: []fetchers.Redirect{
+			{URL: "https://g.org/old", StatusCode: 301, Location: "/new"},
+		}},
+	}
+	var pages, edges bytes.Buffer
+	c := New(WithBaseURL("https://g.org/"), WithMaxDepth(3), WithBreadthFirst(), WithFetcher(f), WithLinkCheck(false),
+		WithPagesCSVWriter(&pages), WithEdgesCSVWriter(&edges))
+	result, err := c.Run(context.Background())
+	assert.Nil(t, err)
+
+	// The redirecting URLs keep the chain but not the final page
+	assert.Equal(t, 301, result.Pages["https://g.org/en"].StatusCode)
+	assert.Empty(t, result.Pages["https://g.org/en"].Links)
+	assert.Len(t, result.Pages["https://g.org/en/"].Links, 1)
+
+	// The final pages are in the tree under the URLs redirecting to them
+	expectedTree := tree.NewNode("https://g.org/")
+	expectedTree.AddChild("https://g.org/en").
+		AddChild("https://g.org/en/").
+		AddChild("https://g.org/en/docs").
+		AddChild("https://g.org/old").
+		AddChild("https://g.org/new")
+	expectedTree.AddChild("https://g.org/fr/")
+	assert.Equal(t, expectedTree, result.Graph.SpanningTree("https://g.org/"))
+
+	// The links of the final pages are written once, from the final URL
+	assert.Equal(t, `source,target,anchor,rel,internal
+https://g.org/,https://g.org/en,English,,true
+https://g.org/,https://g.org/fr/,French,,true
+https://g.org/fr/,https://g.org/en,English,,true
+https://g.org/en/,https://g.org/en/docs,Docs,,true
+https://g.org/en/docs,https://g.org/old,Old,,true
+`, edges.String())
+	assert.NotContains(t, pages.String(), "https://g.org/en,")
+	assert.NotContains(t, pages.String(), "https://g.org/old,")
+	assert.Contains(t, pages.String(), "https://g.org/en/,1,200,,1,1,")
+
+	// The alternates of the final page are checked at the final URL
+	assert.Empty(t, result.HreflangIssues)
+
+	// The links to a redirecting URL count for its final URL
+	inbound := make(map[string]int)
+	for _, score := range result.Scores {
+		inbound[score.URL] = score.Inbound
+	}
+	assert.Equal(t, map[string]int{
+		"https://g.org/":        0,
+		"https://g.org/fr/":     1,
+		"https://g.org/en/":     2,
+		"https://g.org/en/docs": 1,
+		"https://g.org/new":     1,
+	}, inbound)
+
+	// The final URL of a checked link is listed in the sitemap
+	var locs []string
+	for _, url := range c.state.SiteMapURLs(sitemap.Config{}, gOrgInternal) {
+		locs = append(locs, url.Loc)
+	}
+	assert.Equal(t, []string{"https://g.org/", "https://g.org/fr/", "https://g.org/en/", "https://g.org/en/docs", "https://g.org/new"}, locs)
+}
+
+func TestWriteRedirects(t *testing.T) {
+	var buf bytes.Buffer
+	chains := []RedirectChain{
+		{URL: "https://g.org/old", FinalURL: "https://g.org/new", TooLong: true, Hops: []fetchers.Redirect{
+			{URL: "https://g.org/old", StatusCode: 302, Location: "/older"},
+			{URL: "https://g.org/older", StatusCode: 301, Location: "https://g.org/new"},
+		}, Referrers: []Referrer{{Page: "https://g.org/", Text: "Old page"}}},
+		{URL: "https://g.org/a", FinalURL: "https://g.org/b", Loop: true, Hops: []fetchers.Redirect{
+			{URL: "https://g.org/a", StatusCode: 302, Location: "/b"},
+			{URL: "https://g.org/b", StatusCode: 302, Location: "/a"},
+		}},
+		{URL: "https://g.org/c", FinalURL: "https://g.org/d", Hops: []fetchers.Redirect{
+			{URL: "https://g.org/c", StatusCode: 308, Location: "/d"},
+		}},
+	}
+	assert.Nil(t, WriteRedirects(&buf, chains))
+	expected := `https://g.org/old -> https://g.org/new (2 hops, chain too long)
+	302 https://g.org/old -> /older
+	301 https://g.org/older -> https://g.org/new
+	linked from https://g.org/ ("Old page")
+https://g.org/a -> https://g.org/b (2 hops, redirect loop)
+	302 https://g.org/a -> /b
+	302 https://g.org/b -> /a
+https://g.org/c -> https://g.org/d (1 hops)
+	308 https://g.org/c -> /d
+
+3 redirects found: 1 loops, 1 chains too long
+`
+	assert.Equal(t, expected, buf.String())
+}
+
+// redirectFetcher is a Fetcher returning canned page results. The pages
+// without a FinalURL are reached without redirects.
+type redirectFetcher map[string]*fetchers.PageResult
+
+func (f redirectFetcher) Fetch(ctx context.Context, url string, noOpExtractor fetchers.LinksExtractor) *fetchers.PageResult {
+	page, ok := f[url]
+	if !ok {
+		return &fetchers.PageResult{URL: url, FinalURL: url, StatusCode: 404}
+	}
+	result := *page
+	result.URL = url
+	if result.FinalURL == "" {
+		result.FinalURL = url
+	}
+	return &result
+}
+
+func TestRobotsSkipped(t *testing.T) {
+	f := robotsFetcher{Fetcher: ffetcher, disallowed: "https://g.org/cmd/"}
+	result, err := New(WithBaseURL("https://g.org/"), WithMaxDepth(2), WithFetcher(f)).Run(context.Background())
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"https://g.org/cmd/"}, result.RobotsSkipped)
+	assert.Empty(t, result.Errors)
+	// "/" and "/pkg/" are crawled
+	assert.Equal(t, 2, result.CrawledURLs)
+}
+
+// robotsFetcher is a Fetcher which disallows fetching a URL
+type robotsFetcher struct {
+	fetchers.Fetcher
+	disallowed string
+}
+
+func (f robotsFetcher) Fetch(ctx context.Context, url string, le fetchers.LinksExtractor) *fetchers.PageResult {
+	if url == f.disallowed {
+		return &fetchers.PageResult{URL: url, Err: fetchers.ErrDisallowedByRobots}
+	}
+	return f.Fetcher.Fetch(ctx, url, le)
+}
+
+func TestConcurrency(t *testing.T) {
+	// The base URL links to 100 pages. At most 3 of them should be fetched
+	// at the same time.
+	f := &countingFetcher{
+		links:   map[string][]string{},
+		started: make(chan struct{}, 101),
+		release: make(chan struct{}),
+	}
+	for i := 0; i < 100; i++ {
+		f.links["https://g.org/"] = append(f.links["https://g.org/"], fmt.Sprintf("https://g.org/%d", i))
+	}
+	c := New(WithBaseURL("https://g.org/"), WithMaxDepth(2), WithConcurrency(3), WithFetcher(f))
+	done := make(chan *Result)
+	go func() {
+		result, err := c.Run(context.Background())
+		assert.Nil(t, err)
+		done <- result
+	}()
+
+	// The base URL is fetched alone
+	<-f.started
+	f.release <- struct{}{}
+	// Every worker blocks on a page, so the pool is full once 3 fetches started
+	started := 0
+	for ; started < 3; started++ {
+		<-f.started
+	}
+	assert.Equal(t, 3, f.fetching())
+	for released := 0; released < 100; released++ {
+		f.release <- struct{}{}
+		if started < 100 {
+			// The released worker picks the next page
+			<-f.started
+			started++
+			assert.Equal(t, 3, f.fetching())
+		}
+	}
+
+	result := <-done
+	assert.Equal(t, 101, result.SeenURLs)
+	assert.Equal(t, 101, result.CrawledURLs)
+	assert.Equal(t, 3, f.maxInFlight)
+}
+
+// countingFetcher is a Fetcher which records the max number of concurrent
+// Fetch calls. Every Fetch call signals started and blocks until the test
+// sends on release.
+type countingFetcher struct {
+	links       map[string][]string
+	started     chan struct{}
+	release     chan struct{}
+	inFlight    int
+	maxInFlight int
+	sync.Mutex
+}
+
+func (f *countingFetcher) Fetch(ctx context.Context, url string, noOpExtractor fetchers.LinksExtractor) *fetchers.PageResult {
+	f.Lock()
+	f.inFlight++
+	if f.inFlight > f.maxInFlight {
+		f.maxInFlight = f.inFlight
+	}
+	f.Unlock()
+	f.started <- struct{}{}
+	<-f.release
+	f.Lock()
+	f.inFlight--
+	f.Unlock()
+	return &fetchers.PageResult{URL: url, StatusCode: 200, Document: fetchers.Document{Links: toLinks(f.links[url])}}
+}
+
+// fetching returns the number of Fetch calls in flight
+func (f *countingFetcher) fetching() int {
+	f.Lock()
+	defer f.Unlock()
+	return f.inFlight
+}
+
+// crawlWith crawls url using the given fetcher and returns the resulting state
+func crawlWith(url string, depth int, fetcher fetchers.Fetcher) *CrawlerState {
+	c := New(WithBaseURL(url), WithMaxDepth(depth), WithFetcher(fetcher))
+	c.state = NewCrawlerState()
+	c.crawlAll(context.Background())
+	return c.state
+}
+
+// gOrgInternal checks if the url is part of https://g.org/
+func gOrgInternal(url string) bool {
+	return isPartOfDomain("https://g.org/", url)
+}
+
+// allInternal considers every URL part of the site
+func allInternal(string) bool {
+	return true
+}
+
+// toLinks converts the URLs to links without anchor text
+func toLinks(urls []string) []fetchers.Link {
+	var links []fetchers.Link
+	for _, url := range urls {
+		links = append(links, fetchers.Link{URL: url})
+	}
+	return links
+}
+
 // Inspired from https://tour.golang.org/concurrency/10
 // fakeFetcher is Fetcher that returns canned results.
 type fakeFetcher map[string][]string
 
-func (f fakeFetcher) Fetch(url string, noOpExtractor fetchers.LinksExtractor) ([]string, error) {
+func (f fakeFetcher) Fetch(ctx context.Context, url string, noOpExtractor fetchers.LinksExtractor) *fetchers.PageResult {
+	if err := ctx.Err(); err != nil {
+		return &fetchers.PageResult{URL: url, Err: err}
+	}
 	if res, ok := f[url]; ok {
-		return res, nil
+		return &fetchers.PageResult{URL: url, StatusCode: 200, Document: fetchers.Document{Links: toLinks(res)}}
 	}
-	return nil, fmt.Errorf("not found: %s", url)
+	return &fetchers.PageResult{URL: url, Err: fmt.Errorf("not found: %s", url)}
 }
 
 // fetcher is a populated fakeFetcher.
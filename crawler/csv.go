@@ -0,0 +1,87 @@
+package crawler
+
+import (
+	"encoding/csv"
+	"io"
+	"strconv"
+)
+
+// WritePagesCSV writes a CSV table of the URLs seen so far, in the order
+// they were seen. The URLs which redirect are left out, their final URL is
+// listed instead. The status and the title are empty for the URLs which
+// were not fetched. The scores are empty for the URLs without a score (see
+// ComputeScores). Sample table
+//
+//	url,depth,status,title,inbound,outbound,pagerank,hub,authority
+//	https://foo.com/,0,200,Home,1,2,0.412360,0.500000,1.000000
+//	https://foo.com/missing,1,404,,1,0,0.294820,0.000000,0.500000
+func (c *CrawlerState) WritePagesCSV(w io.Writer) error {
+	c.Lock()
+	defer c.Unlock()
+	writer := csv.NewWriter(w)
+	writer.Write([]string{"url", "depth", "status", "title", "inbound", "outbound", "pagerank", "hub", "authority"})
+	for _, url := range c.urls {
+		var status, title string
+		outbound := 0
+		if page, ok := c.pages[url]; ok {
+			if isRedirected(url, page) {
+				continue
+			}
+			if page.StatusCode != 0 {
+				status = strconv.Itoa(page.StatusCode)
+			}
+			title = page.Title
+			outbound = len(page.Links)
+		}
+		var pageRank, hub, authority string
+		if score, ok := c.scores[url]; ok {
+			pageRank = strconv.FormatFloat(score.PageRank, 'f', 6, 64)
+			hub = strconv.FormatFloat(score.Hub, 'f', 6, 64)
+			authority = strconv.FormatFloat(score.Authority, 'f', 6, 64)
+		}
+		writer.Write([]string{
+			url,
+			strconv.Itoa(c.depths[url]),
+			status,
+			title,
+			strconv.Itoa(len(c.graph.InEdges(url))),
+			strconv.Itoa(outbound),
+			pageRank,
+			hub,
+			authority,
+		})
+	}
+	writer.Flush()
+	return writer.Error()
+}
+
+// WriteEdgesCSV writes a CSV table of the links found on the crawled pages,
+// in the order the pages were seen. The links of a page which was reached
+// through redirects are written once, from its final URL. Sample table
+//
+//	source,target,anchor,rel,internal
+//	https://foo.com/,https://foo.com/about,About us,,true
+//	https://foo.com/,https://bar.com/,Bar,nofollow,false
+func (c *CrawlerState) WriteEdgesCSV(w io.Writer) error {
+	c.Lock()
+	defer c.Unlock()
+	writer := csv.NewWriter(w)
+	writer.Write([]string{"source", "target", "anchor", "rel", "internal"})
+	for _, url := range c.urls {
+		page, ok := c.pages[url]
+		if !ok || isRedirected(url, page) {
+			continue
+		}
+		for _, link := range page.Links {
+			writer.Write([]string{
+				url,
+				link.URL,
+				link.Text,
+				link.Rel,
+				strconv.FormatBool(isPartOfDomain(url, link.URL)),
+			})
+		}
+	}
+	writer.Flush()
+	return writer.Error()
+}
@@ -0,0 +1,71 @@
+package crawler
+
+import (
+	"sync"
+)
+
+// task is a single URL waiting to be crawled
+type task struct {
+	url       string
+	parent    string // parent is the URL of the page the URL was found on. Empty for the base URL
+	depth     int    // the remaining depth
+	checkOnly bool   // checkOnly is set if only the status of the URL should be checked
+}
+
+// frontier is the queue of URLs waiting to be crawled. It is drained by the
+// crawler workers. The frontier is closed once all the pushed tasks are done.
+// It is go routine safe.
+type frontier struct {
+	queue   []task
+	pending int // pending is the number of tasks queued or being crawled
+	closed  bool
+	cond    *sync.Cond
+	sync.Mutex
+}
+
+// newFrontier returns a new empty frontier
+func newFrontier() *frontier {
+	f := &frontier{}
+	f.cond = sync.NewCond(&f.Mutex)
+	return f
+}
+
+// push adds a new task to the end of the queue.
+func (f *frontier) push(t task) {
+	f.Lock()
+	f.pending++
+	f.queue = append(f.queue, t)
+	f.Unlock()
+	f.cond.Signal()
+}
+
+// pop removes the task at the front of the queue. It blocks until a task is
+// available. Returns false once the frontier is closed.
+func (f *frontier) pop() (task, bool) {
+	f.Lock()
+	defer f.Unlock()
+	for len(f.queue) == 0 && !f.closed {
+		f.cond.Wait()
+	}
+	if len(f.queue) == 0 {
+		return task{}, false
+	}
+	t := f.queue[0]
+	// The popped slot is cleared so that the backing array doesn't keep the
+	// task reachable
+	f.queue[0] = task{}
+	f.queue = f.queue[1:]
+	return t, true
+}
+
+// done marks a popped task as complete. The frontier is closed when there
+// are no pending tasks left.
+func (f *frontier) done() {
+	f.Lock()
+	f.pending--
+	if f.pending == 0 {
+		f.closed = true
+		f.cond.Broadcast()
+	}
+	f.Unlock()
+}
@@ -0,0 +1,75 @@
+package crawler
+
+import (
+	"encoding/json"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/jarifibrahim/webcrawler/fetchers"
+	log "github.com/sirupsen/logrus"
+)
+
+// Record is the JSON record of a crawled URL. One record is written per
+// line as soon as the URL is crawled.
+type Record struct {
+	URL          string   `json:"url"`
+	Parent       string   `json:"parent,omitempty"` // Parent is the page the URL was found on. Empty for the base URL
+	Depth        int      `json:"depth"`            // Depth is the click depth of the URL
+	Status       int      `json:"status"`           // Status is 0 if no response was received
+	ContentType  string   `json:"content_type,omitempty"`
+	ResponseTime float64  `json:"response_time_ms"` // ResponseTime is the time taken to fetch the URL in milliseconds
+	Attempts     int      `json:"attempts"`         // Attempts is the number of requests sent for the URL, including the retries
+	Links        []string `json:"links"`            // Links are the outbound links of the page
+	CheckOnly    bool     `json:"check_only,omitempty"`
+	Error        string   `json:"error,omitempty"`
+}
+
+// newRecord returns the Record of the page fetched for t
+func (c *Crawler) newRecord(t task, page *fetchers.PageResult) Record {
+	record := Record{
+		URL:          t.url,
+		Parent:       t.parent,
+		Depth:        c.clickDepth(t),
+		Status:       page.StatusCode,
+		ContentType:  page.ContentType,
+		ResponseTime: float64(page.ResponseTime) / float64(time.Millisecond),
+		Attempts:     page.Attempts,
+		Links:        fetchers.LinkURLs(page.Links),
+		CheckOnly:    t.checkOnly,
+	}
+	if record.Links == nil {
+		record.Links = []string{}
+	}
+	if page.Err != nil {
+		record.Error = page.Err.Error()
+	}
+	return record
+}
+
+// recordWriter writes Records as JSON Lines. It is go routine safe.
+type recordWriter struct {
+	enc *json.Encoder
+	sync.Mutex
+}
+
+func newRecordWriter(w io.Writer) *recordWriter {
+	return &recordWriter{enc: json.NewEncoder(w)}
+}
+
+// write writes the record on a new line
+func (r *recordWriter) write(record Record) {
+	r.Lock()
+	defer r.Unlock()
+	if err := r.enc.Encode(record); err != nil {
+		log.WithField("url", record.URL).Errorf("Failed to write record: %s", err)
+	}
+}
+
+// record writes the Record of the page fetched for t if the JSON Lines
+// output is enabled
+func (c *Crawler) record(t task, page *fetchers.PageResult) {
+	if c.records != nil {
+		c.records.write(c.newRecord(t, page))
+	}
+}
@@ -0,0 +1,111 @@
+package crawler
+
+import (
+	"fmt"
+	"io"
+)
+
+// WriteBrokenLinks writes a report of the broken links along with the pages
+// linking to them. Sample report
+//
+//	404 https://foo.com/missing
+//		linked from https://foo.com/ ("Missing page")
+//	ERROR https://bar.com/ (Failed to fetch URL: context deadline exceeded)
+//		linked from https://foo.com/about ("Bar")
+//
+//	2 broken links found
+func WriteBrokenLinks(w io.Writer, links []BrokenLink) error {
+	for _, link := range links {
+		var err error
+		if link.Err != nil {
+			_, err = fmt.Fprintf(w, "ERROR %s (%s)\n", link.URL, link.Err)
+		} else {
+			_, err = fmt.Fprintf(w, "%d %s\n", link.StatusCode, link.URL)
+		}
+		if err != nil {
+			return err
+		}
+		for _, referrer := range link.Referrers {
+			if _, err := fmt.Fprintf(w, "\tlinked from %s (%q)\n", referrer.Page, referrer.Text); err != nil {
+				return err
+			}
+		}
+	}
+	_, err := fmt.Fprintf(w, "\n%d broken links found\n", len(links))
+	return err
+}
+
+// WriteHreflangIssues writes a report of the hreflang alternates without a
+// return link. Sample report
+//
+//	https://foo.com/en/ declares https://foo.com/de/ as "de" without a return link
+//
+//	1 non-reciprocal hreflang links found
+func WriteHreflangIssues(w io.Writer, issues []HreflangIssue) error {
+	for _, issue := range issues {
+		if _, err := fmt.Fprintf(w, "%s declares %s as %q without a return link\n", issue.Page, issue.Alternate, issue.Hreflang); err != nil {
+			return err
+		}
+	}
+	_, err := fmt.Fprintf(w, "\n%d non-reciprocal hreflang links found\n", len(issues))
+	return err
+}
+
+// WriteOrphans writes a report of the orphan pages: the pages which are
+// expected (eg. listed in the sitemap) but are not linked from the crawled
+// pages. Sample report
+//
+//	https://foo.com/old-landing-page
+//
+//	1 orphan pages found
+func WriteOrphans(w io.Writer, orphans []string) error {
+	for _, orphan := range orphans {
+		if _, err := fmt.Fprintln(w, orphan); err != nil {
+			return err
+		}
+	}
+	_, err := fmt.Fprintf(w, "\n%d orphan pages found\n", len(orphans))
+	return err
+}
+
+// WriteRedirects writes a report of the URLs which redirect, along with the
+// hops of their chain and the pages linking to them. Sample report
+//
+//	https://foo.com/old -> https://foo.com/new (2 hops, chain too long)
+//		302 https://foo.com/old -> /older
+//		301 https://foo.com/older -> https://foo.com/new
+//		linked from https://foo.com/ ("Old page")
+//	https://foo.com/a -> https://foo.com/b (2 hops, redirect loop)
+//		302 https://foo.com/a -> /b
+//		302 https://foo.com/b -> /a
+//
+//	2 redirects found: 1 loops, 1 chains too long
+func WriteRedirects(w io.Writer, chains []RedirectChain) error {
+	loops, tooLong := 0, 0
+	for _, chain := range chains {
+		var issue string
+		switch {
+		case chain.Loop:
+			issue = ", redirect loop"
+			loops++
+		case chain.TooLong:
+			issue = ", chain too long"
+			tooLong++
+		}
+		if _, err := fmt.Fprintf(w, "%s -> %s (%d hops%s)\n", chain.URL, chain.FinalURL, len(chain.Hops), issue); err != nil {
+			return err
+		}
+		for _, hop := range chain.Hops {
+			if _, err := fmt.Fprintf(w, "\t%d %s -> %s\n", hop.StatusCode, hop.URL, hop.Location); err != nil {
+				return err
+			}
+		}
+		for _, referrer := range chain.Referrers {
+			if _, err := fmt.Fprintf(w, "\tlinked from %s (%q)\n", referrer.Page, referrer.Text); err != nil {
+				return err
+			}
+		}
+	}
+	_, err := fmt.Fprintf(w, "\n%d redirects found: %d loops, %d chains too long\n", len(chains), loops, tooLong)
+	return err
+}
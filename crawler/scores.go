@@ -0,0 +1,82 @@
+package crawler
+
+import (
+	"encoding/json"
+	"io"
+
+	"github.com/jarifibrahim/webcrawler/graph"
+)
+
+// PageScore is the internal link equity of a page. The scores are computed
+// on the graph of the links between the pages of the site, the links to
+// other sites are ignored.
+type PageScore struct {
+	URL       string  `json:"url"`
+	Inbound   int     `json:"inbound"`   // Inbound is the number of internal links to the page
+	PageRank  float64 `json:"pagerank"`  // PageRank is the share of the PageRank of the site
+	Hub       float64 `json:"hub"`       // Hub is high for the pages linking to good authorities
+	Authority float64 `json:"authority"` // Authority is high for the pages linked from good hubs
+}
+
+// ComputeScores computes the PageRank and the hub and authority scores of
+// the URLs seen so far for which internal returns true. The URLs which
+// redirect are left out: the links to them count as links to their final
+// URL. Returns the scores in the order the URLs were seen. The scores are
+// kept for WritePagesCSV and SiteMapURLs.
+func (c *CrawlerState) ComputeScores(internal func(url string) bool, opts graph.RankOptions) []PageScore {
+	c.Lock()
+	defer c.Unlock()
+	g := c.graph.Subgraph(func(node graph.Node) bool {
+		_, seen := c.urlMap[node.URL]
+		return seen && internal(node.URL) && c.finalURL(node.URL) == node.URL
+	})
+	for _, edge := range c.graph.Edges() {
+		finalURL := c.finalURL(edge.Target)
+		_, source := g.Node(edge.Source)
+		_, target := g.Node(finalURL)
+		if finalURL != edge.Target && source && target {
+			edge.Target = finalURL
+			g.AddEdge(edge)
+		}
+	}
+	ranks := g.PageRank(opts)
+	hubs, authorities := g.HITS(opts)
+
+	c.scores = make(map[string]PageScore, len(g.Nodes()))
+	var scores []PageScore
+	for _, url := range c.urls {
+		if _, ok := g.Node(url); !ok {
+			continue
+		}
+		score := PageScore{
+			URL:       url,
+			Inbound:   len(g.InEdges(url)),
+			PageRank:  ranks[url],
+			Hub:       hubs[url],
+			Authority: authorities[url],
+		}
+		c.scores[url] = score
+		scores = append(scores, score)
+	}
+	return scores
+}
+
+// WriteScoresJSON writes the scores as a JSON array. Sample output
+//
+//	[
+//	  {
+//	    "url": "https://foo.com/",
+//	    "inbound": 12,
+//	    "pagerank": 0.214,
+//	    "hub": 0.5,
+//	    "authority": 1
+//	  }
+//	]
+func WriteScoresJSON(w io.Writer, scores []PageScore) error {
+	if scores == nil {
+		scores = []PageScore{}
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(scores)
+}
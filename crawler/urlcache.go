@@ -1,19 +1,29 @@
 package crawler
 
 import (
+	"errors"
 	"io"
 	"sync"
 
-	"github.com/alecthomas/template"
+	"github.com/jarifibrahim/webcrawler/fetchers"
+	"github.com/jarifibrahim/webcrawler/graph"
+	"github.com/jarifibrahim/webcrawler/sitemap"
 	log "github.com/sirupsen/logrus"
 )
 
 // CrawlerState stores the global state of crawling. It is go rountine safe.
 type CrawlerState struct {
-	urlMap          map[string]struct{} // urlMap is used for fast lookup. It is used to ensure we don't crawl a URL twice
-	urls            []string            // urls stores the actual list of URLs seen
-	seenURLCount    int                 // seenURLCount stores the number of URLs. seenURLCount will always be less than or equal to crawledURLCoun
-	crawledURLCount int                 // The number of seen URLs is not equal to the number of crawled URLs. This variable stores the value of crawled URLs
+	urlMap          map[string]struct{}             // urlMap is used for fast lookup. It is used to ensure we don't crawl a URL twice
+	urls            []string                        // urls stores the actual list of URLs seen
+	depths          map[string]int                  // depths stores the click depth at which each URL was seen
+	seenURLCount    int                             // seenURLCount stores the number of URLs. seenURLCount will always be less than or equal to crawledURLCoun
+	crawledURLCount int                             // The number of seen URLs is not equal to the number of crawled URLs. This variable stores the value of crawled URLs
+	checkedURLCount int                             // checkedURLCount stores the number of URLs checked by the link checker
+	errors          []error                         // errors stores the errors occurred while fetching pages
+	robotsSkipped   []string                        // robotsSkipped stores the URLs disallowed by robots.txt
+	pages           map[string]*fetchers.PageResult // pages stores the result of fetching each crawled URL
+	graph           *graph.Graph                    // graph stores every link found, including the links to the URLs already seen
+	scores          map[string]PageScore            // scores stores the scores of the internal URLs. nil until ComputeScores is called
 	sync.Mutex
 }
 
@@ -21,6 +31,9 @@ type CrawlerState struct {
 func NewCrawlerState() *CrawlerState {
 	return &CrawlerState{
 		urlMap: make(map[string]struct{}),
+		depths: make(map[string]int),
+		pages:  make(map[string]*fetchers.PageResult),
+		graph:  graph.New(),
 	}
 }
 
@@ -31,9 +44,32 @@ func (c *CrawlerState) IncrementCrawledCount() {
 	c.Unlock()
 }
 
-// AddURL tries to insert the new url into the global URL cache.
+// IncrementCheckedCount increases the checked URL count by 1
+func (c *CrawlerState) IncrementCheckedCount() {
+	c.Lock()
+	c.checkedURLCount++
+	c.Unlock()
+}
+
+// AddError records an error occurred while crawling
+func (c *CrawlerState) AddError(err error) {
+	c.Lock()
+	c.errors = append(c.errors, err)
+	c.Unlock()
+}
+
+// AddRobotsSkipped records a URL which was not fetched because robots.txt
+// disallows it
+func (c *CrawlerState) AddRobotsSkipped(url string) {
+	c.Lock()
+	c.robotsSkipped = append(c.robotsSkipped, url)
+	c.Unlock()
+}
+
+// AddURL tries to insert the new url into the global URL cache. depth is the
+// number of links between the base URL and url.
 // Returns false if URL was already present and true if not.
-func (c *CrawlerState) AddURL(url string) bool {
+func (c *CrawlerState) AddURL(url string, depth int) bool {
 	c.Lock()
 	if _, ok := c.urlMap[url]; ok {
 		// URL already present. Return false indicating the new url was already present
@@ -41,40 +77,374 @@ func (c *CrawlerState) AddURL(url string) bool {
 		return false
 	}
 	c.urlMap[url] = struct{}{}
+	c.depths[url] = depth
+	node, _ := c.graph.Node(url)
+	node.URL, node.Depth = url, depth
+	c.graph.AddNode(node)
 	c.seenURLCount++
 	c.urls = append(c.urls, url)
 	c.Unlock()
 	return true
 }
 
-// WriteSiteMap generates sitemap from the given list of URLs
-// The sitemap is minimal and contains only the mandatory <loc> field
+// Depth returns the click depth at which the url was seen. Returns false if
+// the url was not seen.
+func (c *CrawlerState) Depth(url string) (int, bool) {
+	c.Lock()
+	defer c.Unlock()
+	depth, ok := c.depths[url]
+	return depth, ok
+}
+
+// SetPage stores the result of fetching the url
+func (c *CrawlerState) SetPage(url string, page *fetchers.PageResult) {
+	c.Lock()
+	c.pages[url] = page
+	node, _ := c.graph.Node(url)
+	node.URL, node.Status = url, page.StatusCode
+	c.graph.AddNode(node)
+	c.Unlock()
+}
+
+// Page returns the result of fetching the url. Returns nil if the url was
+// not fetched.
+func (c *CrawlerState) Page(url string) *fetchers.PageResult {
+	c.Lock()
+	defer c.Unlock()
+	return c.pages[url]
+}
+
+// AddRedirect records that the page fetched for url was reached at finalURL
+// after following redirects. Only the redirect chain is stored under url
+// (see redirectStub) and a link from url to finalURL is added to the graph.
+// The final URL is the canonical page: it is marked as seen at the given
+// depth and the page is stored under it.
+// Returns false if finalURL was already seen, so that its page is only
+// crawled once.
+func (c *CrawlerState) AddRedirect(url, finalURL string, depth int, page *fetchers.PageResult) bool {
+	c.SetPage(url, redirectStub(page))
+	c.AddReferrer(finalURL, Referrer{Page: url})
+	if !c.AddURL(finalURL, depth) {
+		return false
+	}
+	c.SetPage(finalURL, page)
+	return true
+}
+
+// redirectStub returns the result stored for a URL which redirects: the
+// status of the first redirect and the chain, without the content of the
+// final page
+func redirectStub(page *fetchers.PageResult) *fetchers.PageResult {
+	return &fetchers.PageResult{
+		URL:          page.URL,
+		FinalURL:     page.FinalURL,
+		StatusCode:   page.Redirects[0].StatusCode,
+		ResponseTime: page.ResponseTime,
+		Redirects:    page.Redirects,
+		Attempts:     page.Attempts,
+	}
+}
+
+// isRedirected checks if the page fetched for url was reached at another URL
+// or if its redirects were not followed to the end
+func isRedirected(url string, page *fetchers.PageResult) bool {
+	return len(page.Redirects) > 0 && (page.Err != nil || fetchers.NormalizeURL(page.FinalURL) != url)
+}
+
+// finalURL returns the URL at which the page of url was reached. The caller
+// must hold the lock.
+func (c *CrawlerState) finalURL(url string) string {
+	if page, ok := c.pages[url]; ok && page.Err == nil && isRedirected(url, page) {
+		return fetchers.NormalizeURL(page.FinalURL)
+	}
+	return url
+}
+
+// Referrer is a page linking to a URL
+type Referrer struct {
+	Page string // Page is the URL of the page containing the link
+	Text string // Text is the anchor text of the link
+}
+
+// AddReferrer records that the url is linked from the referrer. The link is
+// added to the graph even if the url was already seen.
+func (c *CrawlerState) AddReferrer(url string, referrer Referrer) {
+	c.Lock()
+	c.graph.AddEdge(graph.Edge{
+		Source:   referrer.Page,
+		Target:   url,
+		Text:     referrer.Text,
+		Internal: isPartOfDomain(referrer.Page, url),
+	})
+	c.Unlock()
+}
+
+// referrers returns the pages linking to the url. The caller must hold the
+// lock.
+func (c *CrawlerState) referrers(url string) []Referrer {
+	var referrers []Referrer
+	for _, edge := range c.graph.InEdges(url) {
+		referrers = append(referrers, Referrer{Page: edge.Source, Text: edge.Text})
+	}
+	return referrers
+}
+
+// Graph returns the graph of the links found so far. Every URL seen is a
+// node and every link on the crawled pages is an edge. The graph is updated
+// while crawling, so it must only be read once the crawl is complete.
+func (c *CrawlerState) Graph() *graph.Graph {
+	return c.graph
+}
+
+// InboundLinks returns the number of links to every URL seen so far
+func (c *CrawlerState) InboundLinks() map[string]int {
+	c.Lock()
+	defer c.Unlock()
+	inbound := make(map[string]int, len(c.urls))
+	for _, url := range c.urls {
+		inbound[url] = len(c.graph.InEdges(url))
+	}
+	return inbound
+}
+
+// Orphans returns the URLs of expected which were never reached through the
+// links of the crawled pages, in the order of expected. The URLs are compared
+// without their query and fragment, as the links are.
+func (c *CrawlerState) Orphans(expected []string) []string {
+	c.Lock()
+	defer c.Unlock()
+	var orphans []string
+	reported := make(map[string]bool)
+	for _, url := range expected {
+		if _, ok := c.urlMap[fetchers.NormalizeURL(url)]; ok || reported[url] {
+			continue
+		}
+		reported[url] = true
+		orphans = append(orphans, url)
+	}
+	return orphans
+}
+
+// BrokenLink is a URL which returned a 4xx/5xx status or couldn't be
+// fetched
+type BrokenLink struct {
+	URL        string
+	StatusCode int        // StatusCode is 0 if no response was received
+	Err        error      // Err is set if the URL couldn't be fetched. Eg: timeout
+	Referrers  []Referrer // Referrers are the pages linking to the URL
+}
+
+// BrokenLinks returns the broken links found so far, in the order the URLs
+// were seen
+func (c *CrawlerState) BrokenLinks() []BrokenLink {
+	c.Lock()
+	defer c.Unlock()
+	var broken []BrokenLink
+	for _, url := range c.urls {
+		page, ok := c.pages[url]
+		if !ok || (page.Err == nil && page.StatusCode < 400) {
+			continue
+		}
+		broken = append(broken, BrokenLink{
+			URL:        url,
+			StatusCode: page.StatusCode,
+			Err:        page.Err,
+			Referrers:  c.referrers(url),
+		})
+	}
+	return broken
+}
+
+// RedirectChain is a URL which redirects, along with the hops of the chain
+type RedirectChain struct {
+	URL       string
+	FinalURL  string              // FinalURL is the URL the chain ends at
+	Hops      []fetchers.Redirect // Hops are the redirects of the chain, oldest first
+	Loop      bool                // Loop is set if the redirects lead back to a URL of the chain
+	TooLong   bool                // TooLong is set if the chain has more hops than allowed
+	Referrers []Referrer          // Referrers are the pages linking to the URL
+}
+
+// RedirectChains returns the URLs which redirect, in the order they were
+// seen. The chains with more than maxHops hops, or which the client stopped
+// following, are flagged as too long. The length isn't checked if maxHops
+// is 0.
+func (c *CrawlerState) RedirectChains(maxHops int) []RedirectChain {
+	c.Lock()
+	defer c.Unlock()
+	var chains []RedirectChain
+	for _, url := range c.urls {
+		page, ok := c.pages[url]
+		if !ok || !isRedirected(url, page) {
+			continue
+		}
+		chain := RedirectChain{
+			URL:       url,
+			FinalURL:  page.FinalURL,
+			Hops:      page.Redirects,
+			Loop:      errors.Is(page.Err, fetchers.ErrRedirectLoop),
+			Referrers: c.referrers(url),
+		}
+		chain.TooLong = !chain.Loop && (errors.Is(page.Err, fetchers.ErrTooManyRedirects) || (maxHops > 0 && len(chain.Hops) > maxHops))
+		chains = append(chains, chain)
+	}
+	return chains
+}
+
+// SiteMapURLs returns the sitemap entries of the URLs seen so far for which
+// internal returns true, in the order they were seen. Only the pages which
+// returned a 2xx status or were not fetched are listed: the broken pages,
+// the URLs disallowed by robots.txt and the URLs which redirect are left
+// out. The final URL of a redirect is listed instead. <lastmod> is set for
+// the fetched pages declaring their modification time. The images, the
+// videos and the hreflang alternates of the fetched pages are added if
+// enabled by config.
+func (c *CrawlerState) SiteMapURLs(config sitemap.Config, internal func(url string) bool) []sitemap.URL {
+	c.Lock()
+	defer c.Unlock()
+	robotsSkipped := make(map[string]bool, len(c.robotsSkipped))
+	for _, url := range c.robotsSkipped {
+		robotsSkipped[url] = true
+	}
+	maxInbound := 0
+	for _, node := range c.graph.Nodes() {
+		if inbound := len(c.graph.InEdges(node.URL)); inbound > maxInbound {
+			maxInbound = inbound
+		}
+	}
+	maxPageRank := 0.0
+	for _, score := range c.scores {
+		if score.PageRank > maxPageRank {
+			maxPageRank = score.PageRank
+		}
+	}
+	urls := make([]sitemap.URL, 0, len(c.urls))
+	for _, url := range c.urls {
+		if !internal(url) || robotsSkipped[url] {
+			continue
+		}
+		if page, ok := c.pages[url]; ok && (isRedirected(url, page) || !page.IsSuccess()) {
+			continue
+		}
+		entry := sitemap.URL{
+			Loc:        url,
+			ChangeFreq: config.ChangeFreqFor(url),
+			Priority: config.Priority.Priority(sitemap.PageStats{
+				Depth:       c.depths[url],
+				Inbound:     len(c.graph.InEdges(url)),
+				MaxInbound:  maxInbound,
+				PageRank:    c.scores[url].PageRank,
+				MaxPageRank: maxPageRank,
+			}),
+		}
+		if page, ok := c.pages[url]; ok {
+			entry.LastMod = page.LastModified
+			if config.Images {
+				entry.Images = siteMapImages(page)
+			}
+			if config.Videos {
+				entry.Videos = siteMapVideos(page)
+			}
+			if config.Alternates {
+				for _, alternate := range page.Alternates {
+					entry.Alternates = append(entry.Alternates, sitemap.Alternate{Hreflang: alternate.Hreflang, Href: alternate.URL})
+				}
+			}
+		}
+		urls = append(urls, entry)
+	}
+	return urls
+}
+
+// HreflangIssue is a hreflang alternate without a return link. Page
+// declares Alternate as its Hreflang version but Alternate doesn't declare
+// Page as one of its versions.
+type HreflangIssue struct {
+	Page      string
+	Alternate string
+	Hreflang  string
+}
+
+// HreflangIssues returns the hreflang alternates which are not reciprocal,
+// in the order the pages were seen. The alternates which were not crawled
+// are not checked, nor the URLs which redirect.
+func (c *CrawlerState) HreflangIssues() []HreflangIssue {
+	c.Lock()
+	defer c.Unlock()
+	var issues []HreflangIssue
+	for _, url := range c.urls {
+		page, ok := c.pages[url]
+		if !ok || isRedirected(url, page) {
+			continue
+		}
+		for _, alternate := range page.Alternates {
+			if alternate.URL == url {
+				continue
+			}
+			alternatePage, ok := c.pages[alternate.URL]
+			if !ok || isRedirected(alternate.URL, alternatePage) || !alternatePage.IsSuccess() {
+				continue
+			}
+			if !hasAlternate(alternatePage, url) {
+				issues = append(issues, HreflangIssue{Page: url, Alternate: alternate.URL, Hreflang: alternate.Hreflang})
+			}
+		}
+	}
+	return issues
+}
+
+// hasAlternate checks if the page declares url as one of its alternates
+func hasAlternate(page *fetchers.PageResult, url string) bool {
+	for _, alternate := range page.Alternates {
+		if alternate.URL == url {
+			return true
+		}
+	}
+	return false
+}
+
+// siteMapImages returns the images of the page
+func siteMapImages(page *fetchers.PageResult) []sitemap.Image {
+	var images []sitemap.Image
+	for _, image := range page.Images {
+		images = append(images, sitemap.Image{Loc: image.URL})
+	}
+	return images
+}
+
+// siteMapVideos returns the videos of the page. The title of the page is
+// used for the videos without a title.
+func siteMapVideos(page *fetchers.PageResult) []sitemap.Video {
+	var videos []sitemap.Video
+	for _, video := range page.Videos {
+		title := video.Title
+		if title == "" {
+			title = page.Title
+		}
+		videos = append(videos, sitemap.Video{
+			ThumbnailLoc: video.ThumbnailURL,
+			Title:        title,
+			ContentLoc:   video.URL,
+		})
+	}
+	return videos
+}
+
+// WriteSiteMap generates sitemap from the given list of URLs. The URLs are
+// filtered as done by SiteMapURLs.
+// <lastmod>, <changefreq> and <priority> are added as set by config.
 // Sample sitemap
 //
 // <?xml version="1.0" encoding="UTF-8"?>
-//
 // <urlset xmlns="http://www.sitemaps.org/schemas/sitemap/0.9">
 //   <url>
 //     <loc>http://foo.com</loc>
+//     <lastmod>2020-01-02T15:04:05Z</lastmod>
+//     <priority>1.0</priority>
 //   </url>
 // </urlset>
-func (c *CrawlerState) WriteSiteMap(f io.Writer) {
-	xmlTemplate := `<?xml version="1.0" encoding="UTF-8"?>
-
-<urlset xmlns="http://www.sitemaps.org/schemas/sitemap/0.9">
-{{range $element := . }}
-  <url>
-    <loc>{{$element}}</loc>
-  </url>{{end}}
-</urlset>
-`
-	tmpl, err := template.New("test").Parse(xmlTemplate)
-	if err != nil {
-		log.Error(err)
-		return
-	}
-	err = tmpl.Execute(f, c.urls)
-	if err != nil {
+func (c *CrawlerState) WriteSiteMap(f io.Writer, config sitemap.Config, internal func(url string) bool) {
+	if err := sitemap.Write(f, c.SiteMapURLs(config, internal)); err != nil {
 		log.Error(err)
 	}
 }
@@ -0,0 +1,117 @@
+package fetchers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// HostAuth is the credentials sent to the hosts matching Pattern. Either
+// the basic auth username and password or the bearer token is set.
+type HostAuth struct {
+	// Pattern is matched against the host name using path.Match.
+	// Eg: "staging.example.com" or "*.example.com"
+	Pattern  string
+	Username string
+	Password string
+	Token    string // Token is sent in the Authorization: Bearer header
+}
+
+// ParseHostAuth parses a HostAuth from a string of the form
+// "pattern,user=alice,password=secret" or "pattern,token=secret".
+func ParseHostAuth(s string) (HostAuth, error) {
+	fields := strings.Split(s, ",")
+	auth := HostAuth{Pattern: strings.TrimSpace(fields[0])}
+	if auth.Pattern == "" {
+		return HostAuth{}, errors.New("invalid auth: missing host pattern")
+	}
+	if _, err := path.Match(auth.Pattern, ""); err != nil {
+		return HostAuth{}, fmt.Errorf("invalid auth for %q: %w", auth.Pattern, err)
+	}
+	for _, field := range fields[1:] {
+		// The field isn't part of the error since it may hold a secret
+		kv := strings.SplitN(strings.TrimSpace(field), "=", 2)
+		if len(kv) != 2 {
+			return HostAuth{}, fmt.Errorf("invalid auth for %q: expected key=value", auth.Pattern)
+		}
+		switch kv[0] {
+		case "user":
+			auth.Username = kv[1]
+		case "password":
+			auth.Password = kv[1]
+		case "token":
+			auth.Token = kv[1]
+		default:
+			return HostAuth{}, fmt.Errorf("invalid auth for %q: unknown key %q", auth.Pattern, kv[0])
+		}
+	}
+	switch {
+	case auth.Token != "" && (auth.Username != "" || auth.Password != ""):
+		return HostAuth{}, fmt.Errorf("invalid auth for %q: both a token and a user are set", auth.Pattern)
+	case auth.Token == "" && auth.Username == "":
+		return HostAuth{}, fmt.Errorf("invalid auth for %q: missing user or token", auth.Pattern)
+	}
+	return auth, nil
+}
+
+// apply sets the Authorization header of the request
+func (a HostAuth) apply(req *http.Request) {
+	if a.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+a.Token)
+	} else {
+		req.SetBasicAuth(a.Username, a.Password)
+	}
+}
+
+// WithAuth sends credentials to the hosts matching the patterns of auths.
+// The first matching HostAuth is used. Requests to other hosts are sent
+// without credentials.
+func WithAuth(auths ...HostAuth) FetcherOption {
+	return func(f *SimpleFetcher) {
+		f.auths = append(f.auths, auths...)
+	}
+}
+
+// authorize sets the credentials of the host of the request, if any
+func (f SimpleFetcher) authorize(req *http.Request) {
+	host := req.URL.Hostname()
+	for _, auth := range f.auths {
+		if ok, _ := path.Match(auth.Pattern, host); ok {
+			auth.apply(req)
+			return
+		}
+	}
+}
+
+// Login posts the form to loginURL before the crawl. The session cookies
+// set by the response are reused by the following requests, so the client
+// of the fetcher must have a cookie jar. See ClientConfig.Jar
+// Returns an error if the response has a 4xx or 5xx status.
+func (f SimpleFetcher) Login(ctx context.Context, loginURL string, form url.Values) error {
+	if err := f.wait(ctx, loginURL); err != nil {
+		return err
+	}
+	req, err := f.newRequest(ctx, http.MethodPost, loginURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	resp, err := f.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("login failed: %w", err)
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+	if resp.StatusCode >= 400 {
+		return fmt.Errorf("login failed: %s returned status %d", loginURL, resp.StatusCode)
+	}
+	log.WithField("url", loginURL).Info("Logged in")
+	return nil
+}
@@ -0,0 +1,230 @@
+package fetchers
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/cookiejar"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/net/publicsuffix"
+)
+
+// CookieJar is an http.CookieJar which can be loaded from and saved to a
+// Netscape cookies.txt file, the format used by curl and the browser
+// extensions exporting cookies. It is go routine safe.
+type CookieJar struct {
+	jar     *cookiejar.Jar
+	cookies map[string]jarCookie // cookies stores the cookies to save by domain, path and name
+	sync.Mutex
+}
+
+// jarCookie is a cookie along with the attributes needed to save it
+type jarCookie struct {
+	domain   string // domain is the host the cookie is sent to, without a leading dot
+	hostOnly bool   // hostOnly is false if the cookie is also sent to the subdomains
+	path     string
+	secure   bool
+	httpOnly bool
+	expires  time.Time // expires is zero for session cookies
+	name     string
+	value    string
+}
+
+// NewCookieJar returns an empty cookie jar
+func NewCookieJar() *CookieJar {
+	// cookiejar.New only fails if the options are invalid
+	jar, _ := cookiejar.New(&cookiejar.Options{PublicSuffixList: publicsuffix.List})
+	return &CookieJar{jar: jar, cookies: make(map[string]jarCookie)}
+}
+
+// Cookies returns the cookies to send in a request to u
+func (j *CookieJar) Cookies(u *url.URL) []*http.Cookie {
+	return j.jar.Cookies(u)
+}
+
+// SetCookies stores the cookies of a response from u
+func (j *CookieJar) SetCookies(u *url.URL, cookies []*http.Cookie) {
+	j.jar.SetCookies(u, cookies)
+
+	now := time.Now()
+	host := strings.ToLower(u.Hostname())
+	j.Lock()
+	defer j.Unlock()
+	for _, c := range cookies {
+		cookie := jarCookie{
+			domain:   host,
+			hostOnly: true,
+			path:     c.Path,
+			secure:   c.Secure,
+			httpOnly: c.HttpOnly,
+			name:     c.Name,
+			value:    c.Value,
+		}
+		if c.Domain != "" {
+			var ok bool
+			if cookie.domain, cookie.hostOnly, ok = cookieDomain(host, c.Domain); !ok {
+				// Rejected by the jar as well
+				continue
+			}
+		}
+		if !strings.HasPrefix(cookie.path, "/") {
+			cookie.path = defaultCookiePath(u.Path)
+		}
+		key := cookie.domain + ";" + cookie.path + ";" + cookie.name
+		switch {
+		case c.MaxAge < 0:
+			delete(j.cookies, key)
+			continue
+		case c.MaxAge > 0:
+			cookie.expires = now.Add(time.Duration(c.MaxAge) * time.Second)
+		case !c.Expires.IsZero():
+			if !c.Expires.After(now) {
+				delete(j.cookies, key)
+				continue
+			}
+			cookie.expires = c.Expires
+		}
+		j.cookies[key] = cookie
+	}
+}
+
+// cookieDomain returns the domain a cookie set by host with the Domain
+// attribute is sent to, the same way cookiejar.Jar does. Returns false if
+// the jar rejects the cookie.
+func cookieDomain(host, domain string) (string, bool, bool) {
+	domain = strings.ToLower(strings.TrimPrefix(domain, "."))
+	if net.ParseIP(host) != nil || publicsuffix.List.PublicSuffix(domain) == domain {
+		// The cookie is only sent to the host
+		return host, true, domain == host
+	}
+	if host != domain && !strings.HasSuffix(host, "."+domain) {
+		return "", false, false
+	}
+	return domain, false, true
+}
+
+// defaultCookiePath returns the path of a cookie without a Path attribute
+// set by a response from urlPath. Eg: /docs/page => /docs
+func defaultCookiePath(urlPath string) string {
+	i := strings.LastIndex(urlPath, "/")
+	if i <= 0 {
+		return "/"
+	}
+	return urlPath[:i]
+}
+
+// httpOnlyPrefix is the prefix of the lines of the HttpOnly cookies in a
+// cookies.txt file
+const httpOnlyPrefix = "#HttpOnly_"
+
+// Load adds the cookies of a Netscape cookies.txt file to the jar. Every
+// line has 7 tab separated fields: domain, include subdomains, path, secure,
+// expiry (unix time, 0 for session cookies), name and value. Empty lines
+// and comments are skipped. The expired cookies are ignored.
+func (j *CookieJar) Load(r io.Reader) error {
+	now := time.Now()
+	scanner := bufio.NewScanner(r)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimRight(scanner.Text(), "\r")
+		httpOnly := strings.HasPrefix(text, httpOnlyPrefix)
+		text = strings.TrimPrefix(text, httpOnlyPrefix)
+		if strings.TrimSpace(text) == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		fields := strings.Split(text, "\t")
+		if len(fields) != 7 {
+			return fmt.Errorf("line %d: expected 7 tab separated fields, got %d", line, len(fields))
+		}
+		expiry, err := strconv.ParseInt(fields[4], 10, 64)
+		if err != nil {
+			return fmt.Errorf("line %d: invalid expiry %q", line, fields[4])
+		}
+		domain := strings.TrimPrefix(fields[0], ".")
+		cookie := &http.Cookie{
+			Name:     fields[5],
+			Value:    fields[6],
+			Path:     fields[2],
+			Secure:   strings.EqualFold(fields[3], "TRUE"),
+			HttpOnly: httpOnly,
+		}
+		if strings.EqualFold(fields[1], "TRUE") {
+			cookie.Domain = domain
+		}
+		if expiry > 0 {
+			cookie.Expires = time.Unix(expiry, 0)
+			if !cookie.Expires.After(now) {
+				continue
+			}
+		}
+		u := &url.URL{Scheme: "http", Host: domain, Path: cookie.Path}
+		if cookie.Secure {
+			u.Scheme = "https"
+		}
+		j.SetCookies(u, []*http.Cookie{cookie})
+	}
+	return scanner.Err()
+}
+
+// Save writes the cookies of the jar in the Netscape cookies.txt format.
+// The session cookies are saved with a 0 expiry so that a login session
+// can be reused by the next crawl. Sample file
+//
+//	# Netscape HTTP Cookie File
+//	.example.com	TRUE	/	TRUE	1767225600	theme	dark
+//	#HttpOnly_staging.example.com	FALSE	/	TRUE	0	session	5f2b
+func (j *CookieJar) Save(w io.Writer) error {
+	now := time.Now()
+	j.Lock()
+	cookies := make([]jarCookie, 0, len(j.cookies))
+	for _, cookie := range j.cookies {
+		if cookie.expires.IsZero() || cookie.expires.After(now) {
+			cookies = append(cookies, cookie)
+		}
+	}
+	j.Unlock()
+	sort.Slice(cookies, func(i, k int) bool {
+		a, b := cookies[i], cookies[k]
+		if a.domain != b.domain {
+			return a.domain < b.domain
+		}
+		if a.path != b.path {
+			return a.path < b.path
+		}
+		return a.name < b.name
+	})
+
+	bw := bufio.NewWriter(w)
+	bw.WriteString("# Netscape HTTP Cookie File\n")
+	for _, cookie := range cookies {
+		domain := cookie.domain
+		if !cookie.hostOnly {
+			domain = "." + domain
+		}
+		if cookie.httpOnly {
+			domain = httpOnlyPrefix + domain
+		}
+		var expiry int64
+		if !cookie.expires.IsZero() {
+			expiry = cookie.expires.Unix()
+		}
+		fmt.Fprintf(bw, "%s\t%s\t%s\t%s\t%d\t%s\t%s\n", domain, netscapeBool(!cookie.hostOnly),
+			cookie.path, netscapeBool(cookie.secure), expiry, cookie.name, cookie.value)
+	}
+	return bw.Flush()
+}
+
+// netscapeBool formats a boolean field of a cookies.txt file
+func netscapeBool(b bool) string {
+	if b {
+		return "TRUE"
+	}
+	return "FALSE"
+}
@@ -1,6 +1,7 @@
 package fetchers
 
 import (
+	"context"
 	"fmt"
 	"io"
 	"net/http"
@@ -10,82 +11,433 @@ import (
 
 	log "github.com/sirupsen/logrus"
 	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
 )
 
 // Fetcher represents an object capable of fetching URLs from a given url
 type Fetcher interface {
-	// Fetch returns the slice of URLs found on that page.
+	// Fetch returns the result of fetching the page, including the slice of
+	// URLs found on that page. It never returns nil. PageResult.Err is set
+	// if the page couldn't be fetched.
 	// LinksExtractor allows processing links post fetching.
-	Fetch(string, LinksExtractor) ([]string, error)
+	// The request is aborted when the context is done.
+	Fetch(context.Context, string, LinksExtractor) *PageResult
 }
 
-// Client represents an object capable of performing a GET request
+// LinkChecker is implemented by fetchers which can check if a URL is
+// reachable without extracting its links
+type LinkChecker interface {
+	// Check returns the status of the URL. It never returns nil.
+	Check(context.Context, string) *PageResult
+}
+
+// Client represents an object capable of sending an HTTP request.
+// *http.Client implements it.
 type Client interface {
-	Get(string) (*http.Response, error)
+	Do(*http.Request) (*http.Response, error)
 }
 
 // SimpleFetcher implements Fetcher
 type SimpleFetcher struct {
-	client  Client
-	baseURL string
+	client    Client
+	baseURL   string
+	userAgent string       // userAgent is sent with the requests and used to find the robots.txt rules which apply to the fetcher
+	header    http.Header  // header is sent with every request
+	auths     []HostAuth   // auths are the credentials of the hosts
+	limiter   *RateLimiter // limiter is nil if the requests are not rate limited
+	robots    *robotsCache // robots is nil if robots.txt is ignored
+	retry     RetryPolicy
+	// maxRedirects is the number of redirects followed by the client
+	maxRedirects int
+}
+
+// DefaultUserAgent is the user agent used when WithUserAgent is not provided
+const DefaultUserAgent = "webcrawler"
+
+// FetcherOption configures a SimpleFetcher
+type FetcherOption func(*SimpleFetcher)
+
+// WithRateLimiter rate limits the requests sent by the fetcher.
+func WithRateLimiter(limiter *RateLimiter) FetcherOption {
+	return func(f *SimpleFetcher) {
+		f.limiter = limiter
+	}
+}
+
+// WithUserAgent sets the user agent of the fetcher. It is sent in the
+// User-Agent header and used to find the robots.txt rules which apply to
+// the fetcher.
+func WithUserAgent(userAgent string) FetcherOption {
+	return func(f *SimpleFetcher) {
+		f.userAgent = userAgent
+	}
+}
+
+// WithHeader adds headers sent with every request. Eg: Authorization. The
+// User-Agent header is set by WithUserAgent.
+func WithHeader(header http.Header) FetcherOption {
+	return func(f *SimpleFetcher) {
+		for key, values := range header {
+			for _, value := range values {
+				f.header.Add(key, value)
+			}
+		}
+	}
+}
+
+// WithAcceptLanguage sets the Accept-Language header of the requests.
+// Eg: "fr-FR, fr;q=0.9"
+func WithAcceptLanguage(languages string) FetcherOption {
+	return func(f *SimpleFetcher) {
+		f.header.Set("Accept-Language", languages)
+	}
+}
+
+// WithClient sets the client sending the requests. A client built from
+// DefaultClientConfig is used by default. See NewHTTPClient
+func WithClient(client Client) FetcherOption {
+	return func(f *SimpleFetcher) {
+		f.client = client
+	}
+}
+
+// WithMaxRedirects sets the number of redirects followed by the client of
+// the fetcher. It must match ClientConfig.MaxRedirects of the client: a
+// redirect chain cut after more than n redirects is reported with
+// ErrTooManyRedirects. With zero, the client is not expected to follow the
+// redirects and the redirect response is returned as the page.
+// DefaultMaxRedirects is used by default.
+func WithMaxRedirects(n int) FetcherOption {
+	return func(f *SimpleFetcher) {
+		f.maxRedirects = n
+	}
 }
 
-// NewSimpleFetcher creates a new fetcher with the given base URL. It also
-// creates a new http.Client with 5 seconds timeout
-func NewSimpleFetcher(url string) *SimpleFetcher {
-	simpleClient := http.DefaultClient
-	// Default http client doesn't have a timeout.
-	simpleClient.Timeout = 5 * time.Second
-	return &SimpleFetcher{baseURL: url, client: simpleClient}
+// WithRetryPolicy sets how the requests which failed with a transient error
+// are retried. DefaultRetryPolicy is used by default.
+func WithRetryPolicy(policy RetryPolicy) FetcherOption {
+	return func(f *SimpleFetcher) {
+		f.retry = policy
+	}
+}
+
+// WithIgnoreRobots disables the robots.txt checks. Useful for internal
+// sites.
+func WithIgnoreRobots() FetcherOption {
+	return func(f *SimpleFetcher) {
+		f.robots = nil
+	}
+}
+
+// NewSimpleFetcher creates a new fetcher with the given base URL. Unless
+// WithClient is provided, it also creates a new http.Client from
+// DefaultClientConfig (5 seconds timeout).
+// robots.txt of every host is honored unless WithIgnoreRobots is provided.
+func NewSimpleFetcher(url string, opts ...FetcherOption) *SimpleFetcher {
+	f := &SimpleFetcher{
+		baseURL:   url,
+		userAgent: DefaultUserAgent,
+		header:    make(http.Header),
+		limiter:   NewRateLimiter(),
+		robots:    newRobotsCache(),
+		retry:     DefaultRetryPolicy,
 
+		maxRedirects: DefaultMaxRedirects,
+	}
+	for _, opt := range opts {
+		opt(f)
+	}
+	if f.client == nil {
+		// DefaultClientConfig has no files to load, so it can't fail
+		f.client, _ = NewHTTPClient(DefaultClientConfig)
+	}
+	return f
 }
 
 // Fetch pulls all the URLs on the page at `url`.
-// Returns list of URLs found on the page
-func (f SimpleFetcher) Fetch(url string, le LinksExtractor) ([]string, error) {
+// Returns the status, headers and timing of the response along with the
+// list of URLs found on the page. The links are extracted only from HTML
+// pages with a 2xx status. PageResult.Err is ErrDisallowedByRobots if
+// robots.txt doesn't allow fetching the page, ErrRedirectLoop or
+// ErrTooManyRedirects if the client stopped following the redirects. The
+// transient failures are retried as set by the retry policy.
+func (f SimpleFetcher) Fetch(ctx context.Context, url string, le LinksExtractor) *PageResult {
 	contextLogger := log.WithField("url", url)
 
-	resp, err := f.client.Get(url)
-	if err != nil {
-		contextLogger.Errorf("Failed to fetch URL: %s", err)
-		return nil, fmt.Errorf("Failed to fetch URL: %s", err)
+	if page := f.beforeRequest(ctx, url); page != nil {
+		return page
 	}
 
+	start := time.Now()
+	resp, attempts, err := f.doWithRetry(ctx, http.MethodGet, url)
+	if err != nil {
+		contextLogger.WithField("attempts", attempts).Errorf("Failed to fetch URL: %s", err)
+		page := failedPageResult(url, time.Since(start), err)
+		page.Attempts = attempts
+		return page
+	}
 	defer resp.Body.Close()
-	return le(f.baseURL, url, resp.Body), nil
+
+	page := newPageResult(url, resp, f.maxRedirects)
+	page.Attempts = attempts
+	if page.IsSuccess() && page.IsHTML() {
+		body := &countingReader{r: resp.Body}
+		if doc := le(f.baseURL, page.FinalURL, body); doc != nil {
+			// The Last-Modified header is used if the page doesn't declare it
+			if doc.LastModified.IsZero() {
+				doc.LastModified = page.LastModified
+			}
+			page.Document = *doc
+		}
+		// Read the rest of the body to find its size
+		if _, err := io.Copy(io.Discard, body); err == nil && page.ContentLength < 0 {
+			page.ContentLength = body.n
+		}
+	}
+	page.ResponseTime = time.Since(start)
+	return page
+}
+
+// Check sends a HEAD request to the url and returns the status of the
+// response. The links on the page are not extracted. Falls back to a GET
+// request if the server doesn't support HEAD.
+func (f SimpleFetcher) Check(ctx context.Context, url string) *PageResult {
+	contextLogger := log.WithField("url", url)
+
+	if page := f.beforeRequest(ctx, url); page != nil {
+		return page
+	}
+
+	start := time.Now()
+	resp, attempts, err := f.doWithRetry(ctx, http.MethodHead, url)
+	if err == nil && (resp.StatusCode == http.StatusMethodNotAllowed || resp.StatusCode == http.StatusNotImplemented) {
+		resp.Body.Close()
+		contextLogger.Info("HEAD not supported. Retrying with GET")
+		var getAttempts int
+		resp, getAttempts, err = f.doWithRetry(ctx, http.MethodGet, url)
+		attempts += getAttempts
+	}
+	if err != nil {
+		contextLogger.WithField("attempts", attempts).Errorf("Failed to check URL: %s", err)
+		page := failedPageResult(url, time.Since(start), err)
+		page.Attempts = attempts
+		return page
+	}
+	resp.Body.Close()
+
+	page := newPageResult(url, resp, f.maxRedirects)
+	page.Attempts = attempts
+	page.ResponseTime = time.Since(start)
+	return page
+}
+
+// Get sends a GET request to the url with the headers and the credentials
+// of the fetcher, using its client. Unlike Fetch, robots.txt, the rate
+// limiter and the retry policy are ignored. Useful to download the inputs
+// of a crawl. The caller must close the body of the response.
+func (f SimpleFetcher) Get(ctx context.Context, url string) (*http.Response, error) {
+	return f.do(ctx, http.MethodGet, url)
+}
+
+// beforeRequest checks robots.txt and waits for the rate limiter. Returns a
+// failed PageResult if the request must not be sent, nil otherwise.
+func (f SimpleFetcher) beforeRequest(ctx context.Context, url string) *PageResult {
+	if !f.robotsAllowed(ctx, url) {
+		log.WithField("url", url).Info("URL disallowed by robots.txt")
+		return &PageResult{URL: url, FinalURL: url, ContentLength: -1, Err: ErrDisallowedByRobots}
+	}
+	if err := f.wait(ctx, url); err != nil {
+		return &PageResult{URL: url, FinalURL: url, ContentLength: -1, Err: err}
+	}
+	return nil
+}
+
+// do sends a single request to the url. The request is bound to ctx.
+func (f SimpleFetcher) do(ctx context.Context, method, url string) (*http.Response, error) {
+	req, err := f.newRequest(ctx, method, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	return f.client.Do(req)
 }
 
-// LinksExtractor extracts links from a given io.Reader
+// newRequest returns a request with the headers and the credentials of the
+// fetcher
+func (f SimpleFetcher) newRequest(ctx context.Context, method, url string, body io.Reader) (*http.Request, error) {
+	req, err := http.NewRequestWithContext(ctx, method, url, body)
+	if err != nil {
+		return nil, err
+	}
+	req.Header = f.header.Clone()
+	req.Header.Set("User-Agent", f.userAgent)
+	f.authorize(req)
+	return req, nil
+}
+
+// failedPageResult returns the PageResult of a request which failed with err
+func failedPageResult(url string, responseTime time.Duration, err error) *PageResult {
+	return &PageResult{
+		URL:           url,
+		FinalURL:      url,
+		ContentLength: -1,
+		ResponseTime:  responseTime,
+		Err:           fmt.Errorf("Failed to fetch URL: %w", err),
+	}
+}
+
+// wait blocks until the rate limiter allows a request to the host of rawURL
+func (f SimpleFetcher) wait(ctx context.Context, rawURL string) error {
+	if f.limiter == nil {
+		return nil
+	}
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		// Let the client report the invalid URL
+		return nil
+	}
+	return f.limiter.Wait(ctx, u.Hostname())
+}
+
+// Link is a link found on a page
+type Link struct {
+	URL  string // URL is the absolute URL of the link
+	Text string // Text is the anchor text of the link
+	Rel  string // Rel is the value of the rel attribute of the link
+}
+
+// Alternate is a language version of a page declared by
+// <link rel="alternate" hreflang="de" href="...">
+type Alternate struct {
+	URL      string // URL is the absolute URL of the alternate page
+	Hreflang string // Hreflang is the language of the alternate page. Eg: "de", "en-GB" or "x-default"
+}
+
+// LinkURLs returns the URLs of the links
+func LinkURLs(links []Link) []string {
+	var urls []string
+	for _, link := range links {
+		urls = append(urls, link.URL)
+	}
+	return urls
+}
+
+// LinksExtractor extracts links and metadata from a given io.Reader
 // It allows user to customize how the links should be extracted from given
-// page.
-type LinksExtractor func(baseURL string, currentURL string, response io.Reader) []string
+// page. baseURL is the URL the crawl started from and currentURL is the URL
+// of the page.
+type LinksExtractor func(baseURL string, currentURL string, response io.Reader) *Document
 
 // SimpleLinkExtractor satisfies LinksExtractor.
-// It reads the body and extracts the valid links
-func SimpleLinkExtractor(baseURL, currentURL string, body io.Reader) []string {
+// It reads the body and extracts the valid links along with their anchor
+// text. Relative links are resolved against currentURL, or against the
+// <base href> of the page if present. The modification time is read from
+// the <meta> tags of the page. The title, the images, the videos and the
+// hreflang alternates of the page are collected as well.
+func SimpleLinkExtractor(baseURL, currentURL string, body io.Reader) *Document {
 	contextLogger := log.WithField("base_url", baseURL)
 
-	var urlList []string
-	// URLset is used to ensure urlList is always unique
+	doc := &Document{}
+	media := newMediaCollector()
+	// inTitle is set while the <title> of the page is read
+	inTitle := false
+	var linkList []Link
+	// URLset is used to ensure linkList is always unique
 	URLset := make(map[string]struct{})
+	// Links are resolved against the URL of the page, or against the
+	// <base href> of the page if it has one.
+	pageBaseURL := currentURL
+	baseFound := false
+	// anchorText collects the text of the <a> tag being read. openLink is
+	// the index of its link in linkList, -1 if the text isn't collected.
+	var anchorText strings.Builder
+	openLink := -1
+	closeLink := func() {
+		if openLink >= 0 {
+			linkList[openLink].Text = strings.Join(strings.Fields(anchorText.String()), " ")
+		}
+		anchorText.Reset()
+		openLink = -1
+	}
+
 	tokenizer := html.NewTokenizer(body)
 	for {
 		tt := tokenizer.Next()
 		switch tt {
 		case html.ErrorToken:
-			return urlList
-		case html.StartTagToken:
+			closeLink()
+			doc.Links = linkList
+			doc.Images = media.images
+			doc.Videos = media.videos
+			doc.Title = strings.Join(strings.Fields(doc.Title), " ")
+			return doc
+		case html.TextToken:
+			if openLink >= 0 {
+				anchorText.Write(tokenizer.Text())
+			}
+			if inTitle {
+				doc.Title += string(tokenizer.Text())
+			}
+		case html.EndTagToken:
+			name, _ := tokenizer.TagName()
+			switch a := atom.Lookup(name); a {
+			case atom.A:
+				closeLink()
+			case atom.Title:
+				inTitle = false
+			default:
+				media.endTag(a)
+			}
+		case html.StartTagToken, html.SelfClosingTagToken:
 			token := tokenizer.Token()
+			media.startTag(token, pageBaseURL)
+			// Only the first <title> of the page is used
+			if token.DataAtom == atom.Title && tt == html.StartTagToken && doc.Title == "" {
+				inTitle = true
+				continue
+			}
+			// Only the first <base> element of the page is used
+			if token.DataAtom == atom.Base && !baseFound {
+				if href := findHrefValue(token); href != nil && *href != "" {
+					baseFound = true
+					if builtURL, err := resolveURL(currentURL, *href); err == nil {
+						pageBaseURL = builtURL
+					} else {
+						contextLogger.Infof("Failed to build base URL: %s", err)
+					}
+				}
+				continue
+			}
+			if token.DataAtom == atom.Link {
+				if alternate, ok := findAlternate(token, pageBaseURL); ok {
+					doc.Alternates = append(doc.Alternates, alternate)
+				}
+				continue
+			}
+			if token.DataAtom == atom.Meta {
+				if lastModified, ok := findLastModified(token); ok && doc.LastModified.IsZero() {
+					doc.LastModified = lastModified
+				}
+				continue
+			}
+			// Use the alt text of images inside links as the anchor text
+			if token.DataAtom == atom.Img && openLink >= 0 {
+				if alt, ok := findAttrValue(token, "alt"); ok {
+					anchorText.WriteString(" " + alt + " ")
+				}
+				continue
+			}
 			// Token isn't a <a> tag. Skip token and continue the loop
-			if token.DataAtom.String() != "a" {
+			if token.DataAtom != atom.A {
 				continue
 			}
+			// A new <a> tag ends the previous one
+			closeLink()
 			href := findHrefValue(token)
 			if href == nil {
 				continue
 			}
 
-			builtURL, err := buildURL(baseURL, *href)
+			builtURL, err := buildURL(pageBaseURL, *href)
 
 			if err != nil {
 				// error occurred while trying to build the URL. Log the error
@@ -94,7 +446,7 @@ func SimpleLinkExtractor(baseURL, currentURL string, body io.Reader) []string {
 				continue
 			}
 
-			// If we've already added this URL to urlList, don't add it again
+			// If we've already added this URL to linkList, don't add it again
 			if _, ok := URLset[builtURL]; ok {
 				continue
 			}
@@ -102,14 +454,80 @@ func SimpleLinkExtractor(baseURL, currentURL string, body io.Reader) []string {
 			// add URL to URLset
 			URLset[builtURL] = struct{}{}
 
-			// if the new url is equal to the baseURL don't add it
+			// if the new url is equal to the current URL don't add it
 			if builtURL == currentURL {
-				contextLogger.Infof("base url equals child URL %s", builtURL)
+				contextLogger.Infof("current url equals child URL %s", builtURL)
 				continue
 			}
-			urlList = append(urlList, builtURL)
+			rel, _ := findAttrValue(token, "rel")
+			linkList = append(linkList, Link{URL: builtURL, Rel: rel})
+			if tt == html.StartTagToken {
+				openLink = len(linkList) - 1
+			}
+		}
+	}
+}
+
+// findAttrValue returns the value of the attribute key of the token
+func findAttrValue(t html.Token, key string) (string, bool) {
+	for _, attr := range t.Attr {
+		if strings.EqualFold(attr.Key, key) {
+			return attr.Val, true
 		}
 	}
+	return "", false
+}
+
+// findAlternate returns the alternate declared by a <link> tag with
+// rel="alternate" and a hreflang attribute
+func findAlternate(t html.Token, baseURL string) (Alternate, bool) {
+	rel, _ := findAttrValue(t, "rel")
+	hreflang, ok := findAttrValue(t, "hreflang")
+	href := findHrefValue(t)
+	if !ok || href == nil || !strings.Contains(" "+strings.ToLower(rel)+" ", " alternate ") {
+		return Alternate{}, false
+	}
+	alternateURL, err := buildURL(baseURL, *href)
+	if err != nil {
+		return Alternate{}, false
+	}
+	return Alternate{URL: alternateURL, Hreflang: strings.TrimSpace(hreflang)}, true
+}
+
+// lastModifiedMeta are the names of the <meta> tags declaring the
+// modification time of a page
+var lastModifiedMeta = map[string]bool{
+	"article:modified_time": true,
+	"og:updated_time":       true,
+	"last-modified":         true,
+	"datemodified":          true,
+	"dcterms.modified":      true,
+}
+
+// lastModifiedLayouts are the accepted formats of the modification time
+var lastModifiedLayouts = []string{time.RFC3339, "2006-01-02T15:04:05", "2006-01-02", http.TimeFormat, time.RFC850, time.ANSIC}
+
+// findLastModified returns the modification time declared by a <meta> tag.
+// Eg: <meta property="article:modified_time" content="2020-01-02T15:04:05Z">
+func findLastModified(t html.Token) (time.Time, bool) {
+	declared := false
+	for _, key := range []string{"name", "property", "itemprop", "http-equiv"} {
+		if name, ok := findAttrValue(t, key); ok && lastModifiedMeta[strings.ToLower(name)] {
+			declared = true
+			break
+		}
+	}
+	content, ok := findAttrValue(t, "content")
+	if !declared || !ok {
+		return time.Time{}, false
+	}
+	content = strings.TrimSpace(content)
+	for _, layout := range lastModifiedLayouts {
+		if lastModified, err := time.Parse(layout, content); err == nil {
+			return lastModified, true
+		}
+	}
+	return time.Time{}, false
 }
 
 func findHrefValue(t html.Token) *string {
@@ -121,15 +539,16 @@ func findHrefValue(t html.Token) *string {
 	return nil
 }
 
-// buildURL builds an absolute URL from the given baseURL and href
+// buildURL builds an absolute URL from the given baseURL and href as
+// defined by RFC 3986. The query and the fragment of href are removed.
 // Eg: http://foo.com + /bar => http://foo.com/bar
 // 	   http://foo.com + http://bar.com => http://bar.com
 //	   http://foo.com + #content => http://foo.com
+//	   http://foo.com/docs/ + child.html => http://foo.com/docs/child.html
 func buildURL(baseURL string, href string) (string, error) {
-	// Discard invalid href values. Return error
-	if href == "" ||
-		len(href) == 1 ||
-		strings.HasPrefix(href, "#") {
+	href = strings.TrimSpace(href)
+	// An empty href or a fragment refers to the base itself
+	if href == "" || strings.HasPrefix(href, "#") {
 		return baseURL, nil
 	}
 
@@ -139,8 +558,30 @@ func buildURL(baseURL string, href string) (string, error) {
 	}
 	// Remove query params, if any
 	u.RawQuery = ""
+	u.ForceQuery = false
 	// Remove fragment, if any
 	u.Fragment = ""
+	return resolveURL(baseURL, u.String())
+}
+
+// NormalizeURL returns rawURL without its query params and fragment, the
+// way the links found by SimpleLinkExtractor are. rawURL is returned as is if
+// it can't be parsed.
+func NormalizeURL(rawURL string) string {
+	normalized, err := buildURL(rawURL, rawURL)
+	if err != nil {
+		return rawURL
+	}
+	return normalized
+}
+
+// resolveURL resolves the reference href against baseURL as defined by
+// RFC 3986
+func resolveURL(baseURL string, href string) (string, error) {
+	u, err := url.Parse(strings.TrimSpace(href))
+	if err != nil {
+		return "", err
+	}
 	base, err := url.Parse(baseURL)
 	if err != nil {
 		return "", err
@@ -1,11 +1,31 @@
 package fetchers
 
 import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
 	"fmt"
 	"io/ioutil"
+	"math/big"
+	"net"
 	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
 	"strings"
+	"sync"
+	"syscall"
 	"testing"
+	"time"
 
 	"golang.org/x/net/html/atom"
 
@@ -24,17 +44,22 @@ type fakeClient struct {
 	responseCache map[string]string
 }
 
-func (fc fakeClient) Get(url string) (*http.Response, error) {
-	if res, ok := fc.responseCache[url]; ok {
+func (fc fakeClient) Do(req *http.Request) (*http.Response, error) {
+	if err := req.Context().Err(); err != nil {
+		return nil, err
+	}
+	if res, ok := fc.responseCache[req.URL.String()]; ok {
 		return &http.Response{
 			Body: ioutil.NopCloser(strings.NewReader(res)),
 		}, nil
 	}
-	return nil, fmt.Errorf("not found: %s", url)
+	return nil, fmt.Errorf("not found: %s", req.URL)
 }
+
 func TestSimpleFetcher(t *testing.T) {
 	fakeClient := fakeClient{
 		responseCache: map[string]string{
+			"http://localhost:8000/robots.txt": "",
 			"http://localhost:8000/foobar": `
 			<html>
 			<body>
@@ -55,16 +80,231 @@ func TestSimpleFetcher(t *testing.T) {
 	testFetcher := NewSimpleFetcher("http://localhost:8000/foobar")
 	testFetcher.client = fakeClient
 	t.Run("success", func(t *testing.T) {
-		result, err := testFetcher.Fetch(testFetcher.baseURL, SimpleLinkExtractor)
-		assert.Nil(t, err)
-		assert.Equal(t, result, []string{"http://localhost:8000/hello", "http://localhost:8000/bye", "http://localhost:8000/BYE"})
+		result := testFetcher.Fetch(context.Background(), testFetcher.baseURL, SimpleLinkExtractor)
+		assert.Nil(t, result.Err)
+		assert.Equal(t, LinkURLs(result.Links), []string{"http://localhost:8000/hello", "http://localhost:8000/bye", "http://localhost:8000/BYE"})
 	})
 	t.Run("client error", func(t *testing.T) {
-		result, err := testFetcher.Fetch("my/random/url", SimpleLinkExtractor)
-		assert.Nil(t, result)
-		assert.Error(t, err)
+		result := testFetcher.Fetch(context.Background(), "my/random/url", SimpleLinkExtractor)
+		assert.Nil(t, result.Links)
+		assert.Error(t, result.Err)
+	})
+	t.Run("canceled context", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		result := testFetcher.Fetch(ctx, testFetcher.baseURL, SimpleLinkExtractor)
+		assert.Nil(t, result.Links)
+		assert.Error(t, result.Err)
+	})
+	t.Run("rate limited", func(t *testing.T) {
+		// robots.txt is ignored since fetching it also counts as a request
+		limitedFetcher := NewSimpleFetcher(testFetcher.baseURL, WithIgnoreRobots(),
+			WithRateLimiter(NewRateLimiter(HostLimit{Pattern: "localhost", MinDelay: time.Hour})))
+		limitedFetcher.client = fakeClient
+		result := limitedFetcher.Fetch(context.Background(), limitedFetcher.baseURL, SimpleLinkExtractor)
+		assert.Nil(t, result.Err)
+		// The next request to localhost is allowed only after an hour
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+		defer cancel()
+		result = limitedFetcher.Fetch(ctx, limitedFetcher.baseURL, SimpleLinkExtractor)
+		assert.Equal(t, context.DeadlineExceeded, result.Err)
+	})
+}
+func TestPageResult(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/page", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		fmt.Fprint(w, "<a href='child'></a>")
+	})
+	mux.HandleFunc("/old", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "/older", http.StatusFound)
+	})
+	mux.HandleFunc("/older", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "/dir/page", http.StatusMovedPermanently)
+	})
+	mux.HandleFunc("/dir/page", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "<html><a href='child'></a></html>")
+	})
+	mux.HandleFunc("/missing", func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "<a href='/child'></a>", http.StatusNotFound)
+	})
+	mux.HandleFunc("/file.pdf", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/pdf")
+		fmt.Fprint(w, "<a href='/child'></a>")
+	})
+	mux.HandleFunc("/modified", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Last-Modified", "Thu, 02 Jan 2020 15:04:05 GMT")
+		fmt.Fprint(w, "<html></html>")
+	})
+	mux.HandleFunc("/modified-meta", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Last-Modified", "Thu, 02 Jan 2020 15:04:05 GMT")
+		fmt.Fprint(w, `<html><meta property="article:modified_time" content="2021-03-04T05:06:07Z"></html>`)
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	f := NewSimpleFetcher(server.URL, WithIgnoreRobots())
+	f.client = server.Client()
+
+	t.Run("success", func(t *testing.T) {
+		result := f.Fetch(context.Background(), server.URL+"/page", SimpleLinkExtractor)
+		assert.Nil(t, result.Err)
+		assert.Equal(t, server.URL+"/page", result.URL)
+		assert.Equal(t, server.URL+"/page", result.FinalURL)
+		assert.Equal(t, http.StatusOK, result.StatusCode)
+		assert.Equal(t, "text/html", result.ContentType)
+		assert.Equal(t, int64(20), result.ContentLength)
+		assert.True(t, result.ResponseTime > 0)
+		assert.Empty(t, result.Redirects)
+		assert.Equal(t, []string{server.URL + "/child"}, LinkURLs(result.Links))
+	})
+	t.Run("redirects", func(t *testing.T) {
+		result := f.Fetch(context.Background(), server.URL+"/old", SimpleLinkExtractor)
+		assert.Nil(t, result.Err)
+		assert.Equal(t, server.URL+"/old", result.URL)
+		assert.Equal(t, server.URL+"/dir/page", result.FinalURL)
+		assert.Equal(t, []Redirect{
+			{URL: server.URL + "/old", StatusCode: http.StatusFound, Location: "/older"},
+			{URL: server.URL + "/older", StatusCode: http.StatusMovedPermanently, Location: "/dir/page"},
+		}, result.Redirects)
+		// Links are resolved against the final URL
+		assert.Equal(t, []string{server.URL + "/dir/child"}, LinkURLs(result.Links))
+	})
+	t.Run("error status", func(t *testing.T) {
+		result := f.Fetch(context.Background(), server.URL+"/missing", SimpleLinkExtractor)
+		assert.Nil(t, result.Err)
+		assert.Equal(t, http.StatusNotFound, result.StatusCode)
+		assert.False(t, result.IsSuccess())
+		assert.Nil(t, result.Links)
 	})
+	t.Run("not html", func(t *testing.T) {
+		result := f.Fetch(context.Background(), server.URL+"/file.pdf", SimpleLinkExtractor)
+		assert.Nil(t, result.Err)
+		assert.Equal(t, "application/pdf", result.ContentType)
+		assert.False(t, result.IsHTML())
+		assert.Nil(t, result.Links)
+	})
+	t.Run("last modified header", func(t *testing.T) {
+		result := f.Fetch(context.Background(), server.URL+"/modified", SimpleLinkExtractor)
+		assert.Equal(t, time.Date(2020, 1, 2, 15, 4, 5, 0, time.UTC), result.LastModified.UTC())
+		assert.True(t, result.IsSuccess())
+		assert.True(t, result.IsHTML())
+	})
+	t.Run("last modified meta", func(t *testing.T) {
+		// The page metadata wins over the header
+		result := f.Fetch(context.Background(), server.URL+"/modified-meta", SimpleLinkExtractor)
+		assert.Equal(t, time.Date(2021, 3, 4, 5, 6, 7, 0, time.UTC), result.LastModified.UTC())
+	})
+}
+
+func TestRedirectChain(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/loop", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "/loop-back", http.StatusFound)
+	})
+	mux.HandleFunc("/loop-back", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "/loop", http.StatusMovedPermanently)
+	})
+	mux.HandleFunc("/self", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "/self", http.StatusFound)
+	})
+	mux.HandleFunc("/hop/", func(w http.ResponseWriter, r *http.Request) {
+		var n int
+		fmt.Sscanf(r.URL.Path, "/hop/%d", &n)
+		http.Redirect(w, r, fmt.Sprintf("/hop/%d", n+1), http.StatusMovedPermanently)
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	client, err := NewHTTPClient(ClientConfig{MaxRedirects: 2})
+	assert.Nil(t, err)
+	f := NewSimpleFetcher(server.URL, WithClient(client), WithMaxRedirects(2), WithIgnoreRobots())
+
+	t.Run("loop", func(t *testing.T) {
+		result := f.Fetch(context.Background(), server.URL+"/loop", SimpleLinkExtractor)
+		assert.Equal(t, ErrRedirectLoop, result.Err)
+		assert.Equal(t, server.URL+"/loop-back", result.FinalURL)
+		assert.Equal(t, http.StatusMovedPermanently, result.StatusCode)
+		assert.Equal(t, []Redirect{
+			{URL: server.URL + "/loop", StatusCode: http.StatusFound, Location: "/loop-back"},
+			{URL: server.URL + "/loop-back", StatusCode: http.StatusMovedPermanently, Location: "/loop"},
+		}, result.Redirects)
+	})
+	t.Run("self", func(t *testing.T) {
+		result := f.Check(context.Background(), server.URL+"/self")
+		assert.Equal(t, ErrRedirectLoop, result.Err)
+		assert.Len(t, result.Redirects, 1)
+	})
+	t.Run("too many redirects", func(t *testing.T) {
+		result := f.Fetch(context.Background(), server.URL+"/hop/0", SimpleLinkExtractor)
+		assert.Equal(t, ErrTooManyRedirects, result.Err)
+		assert.False(t, result.IsSuccess())
+		assert.Equal(t, server.URL+"/hop/2", result.FinalURL)
+		// The 2 redirects followed and the one the client stopped at
+		assert.Len(t, result.Redirects, 3)
+		assert.Equal(t, Redirect{URL: server.URL + "/hop/2", StatusCode: http.StatusMovedPermanently, Location: "/hop/3"}, result.Redirects[2])
+	})
+	t.Run("redirects disabled", func(t *testing.T) {
+		client, err := NewHTTPClient(ClientConfig{MaxRedirects: 0})
+		assert.Nil(t, err)
+		f := NewSimpleFetcher(server.URL, WithClient(client), WithMaxRedirects(0), WithIgnoreRobots())
+		// The redirect is returned as the page
+		result := f.Fetch(context.Background(), server.URL+"/hop/0", SimpleLinkExtractor)
+		assert.Nil(t, result.Err)
+		assert.Equal(t, http.StatusMovedPermanently, result.StatusCode)
+		assert.Equal(t, server.URL+"/hop/0", result.FinalURL)
+		assert.Equal(t, []Redirect{
+			{URL: server.URL + "/hop/0", StatusCode: http.StatusMovedPermanently, Location: "/hop/1"},
+		}, result.Redirects)
+		// A loop is still reported
+		result = f.Check(context.Background(), server.URL+"/self")
+		assert.Equal(t, ErrRedirectLoop, result.Err)
+	})
+	t.Run("client not following redirects", func(t *testing.T) {
+		client := server.Client()
+		client.CheckRedirect = func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		}
+		f := NewSimpleFetcher(server.URL, WithClient(client), WithIgnoreRobots())
+		result := f.Check(context.Background(), server.URL+"/hop/0")
+		assert.Nil(t, result.Err)
+		assert.Len(t, result.Redirects, 1)
+	})
+}
+
+func TestSimpleLinkExtractorLastModified(t *testing.T) {
+	baseURL := "http://site.com"
+	testData := []struct {
+		testName string
+		response string
+		expected time.Time
+	}{
+		{"no meta", "<html><head><title>Foo</title></head></html>", time.Time{}},
+		{"article modified time", `<meta property="article:modified_time" content="2020-01-02T15:04:05Z">`,
+			time.Date(2020, 1, 2, 15, 4, 5, 0, time.UTC)},
+		{"date only", `<meta itemprop="dateModified" content="2020-01-02">`, time.Date(2020, 1, 2, 0, 0, 0, 0, time.UTC)},
+		{"http date", `<meta http-equiv="Last-Modified" content="Thu, 02 Jan 2020 15:04:05 GMT">`,
+			time.Date(2020, 1, 2, 15, 4, 5, 0, time.UTC)},
+		{"first meta wins", `<meta name="last-modified" content="2020-01-02"><meta name="last-modified" content="2021-01-02">`,
+			time.Date(2020, 1, 2, 0, 0, 0, 0, time.UTC)},
+		{"invalid date", `<meta name="last-modified" content="yesterday">`, time.Time{}},
+		{"other meta", `<meta name="description" content="2020-01-02">`, time.Time{}},
+	}
+	for _, tt := range testData {
+		tt := tt
+		t.Run(tt.testName, func(t *testing.T) {
+			doc := SimpleLinkExtractor(baseURL, baseURL, strings.NewReader(tt.response))
+			assert.True(t, tt.expected.Equal(doc.LastModified), doc.LastModified)
+		})
+	}
 }
+
+func TestNormalizeURL(t *testing.T) {
+	assert.Equal(t, "http://foo.com/bar", NormalizeURL("http://foo.com/bar?a=b#top"))
+	assert.Equal(t, "http://foo.com/", NormalizeURL("http://foo.com/"))
+	assert.Equal(t, "http://foo.com/%zz", NormalizeURL("http://foo.com/%zz"))
+}
+
 func TestBuildURL(t *testing.T) {
 	testData := []struct {
 		name        string
@@ -91,6 +331,24 @@ func TestBuildURL(t *testing.T) {
 			"/bar",
 			"/bar",
 			false,
+		}, {
+			"root href",
+			"http://foo.com/bar/",
+			"/",
+			"http://foo.com/",
+			false,
+		}, {
+			"href with spaces",
+			"http://foo.com/bar/",
+			"  baz ",
+			"http://foo.com/bar/baz",
+			false,
+		}, {
+			"invalid href",
+			"http://foo.com",
+			"http://[::1",
+			"",
+			true,
 		},
 	}
 	for _, tt := range testData {
@@ -106,6 +364,63 @@ func TestBuildURL(t *testing.T) {
 		})
 	}
 }
+
+// TestBuildURLRelative checks the reference resolution examples of RFC 3986
+// section 5.4. The query and the fragment of the href are always removed.
+func TestBuildURLRelative(t *testing.T) {
+	baseURL := "http://a/b/c/d;p?q"
+	testData := []struct {
+		href     string
+		expected string
+	}{
+		// Normal examples
+		{"g:h", "g:h"},
+		{"g", "http://a/b/c/g"},
+		{"./g", "http://a/b/c/g"},
+		{"g/", "http://a/b/c/g/"},
+		{"/g", "http://a/g"},
+		{"//g", "http://g"},
+		{"g?y", "http://a/b/c/g"},
+		{"#s", "http://a/b/c/d;p?q"},
+		{"g#s", "http://a/b/c/g"},
+		{"g?y#s", "http://a/b/c/g"},
+		{";x", "http://a/b/c/;x"},
+		{"g;x", "http://a/b/c/g;x"},
+		{"", "http://a/b/c/d;p?q"},
+		{".", "http://a/b/c/"},
+		{"./", "http://a/b/c/"},
+		{"..", "http://a/b/"},
+		{"../", "http://a/b/"},
+		{"../g", "http://a/b/g"},
+		{"../..", "http://a/"},
+		{"../../", "http://a/"},
+		{"../../g", "http://a/g"},
+		// Abnormal examples
+		{"../../../g", "http://a/g"},
+		{"../../../../g", "http://a/g"},
+		{"/./g", "http://a/g"},
+		{"/../g", "http://a/g"},
+		{"g.", "http://a/b/c/g."},
+		{".g", "http://a/b/c/.g"},
+		{"g..", "http://a/b/c/g.."},
+		{"..g", "http://a/b/c/..g"},
+		{"./../g", "http://a/b/g"},
+		{"./g/.", "http://a/b/c/g/"},
+		{"g/./h", "http://a/b/c/g/h"},
+		{"g/../h", "http://a/b/c/h"},
+		{"g;x=1/./y", "http://a/b/c/g;x=1/y"},
+		{"g;x=1/../y", "http://a/b/c/y"},
+	}
+	for _, tt := range testData {
+		tt := tt
+		t.Run(tt.href, func(t *testing.T) {
+			builtURL, err := buildURL(baseURL, tt.href)
+			assert.Nil(t, err)
+			assert.Equal(t, tt.expected, builtURL)
+		})
+	}
+}
+
 func TestFindHref(t *testing.T) {
 	Validtoken := html.Token{
 		Type:     html.StartTagToken,
@@ -159,8 +474,988 @@ func TestSimpleLinkExtractor(t *testing.T) {
 		tt := tt
 		t.Run(tt.testName, func(t *testing.T) {
 			body := strings.NewReader(tt.response)
-			actualURLList := SimpleLinkExtractor(baseURL, baseURL, body)
+			actualURLList := LinkURLs(SimpleLinkExtractor(baseURL, baseURL, body).Links)
 			assert.Equal(t, tt.expectedURLList, actualURLList)
 		})
 	}
 }
+
+func TestSimpleLinkExtractorLinkDetails(t *testing.T) {
+	baseURL := "http://site.com"
+	testData := []struct {
+		testName      string
+		response      string
+		expectedLinks []Link
+	}{
+		{"anchor text", "<a href='/foo'>Foo  <b>Bar</b>\n </a>", []Link{{URL: "http://site.com/foo", Text: "Foo Bar"}}},
+		{"image alt text", "<a href='/foo'><img src='x.png' alt='Logo'></a>", []Link{{URL: "http://site.com/foo", Text: "Logo"}}},
+		{"rel attribute", "<a href='/foo' rel='nofollow noopener'>Foo</a>",
+			[]Link{{URL: "http://site.com/foo", Text: "Foo", Rel: "nofollow noopener"}}},
+		{"text outside links", "Hello <a href='/foo'>Foo</a> World", []Link{{URL: "http://site.com/foo", Text: "Foo"}}},
+		{"unclosed link", "<a href='/foo'>Foo", []Link{{URL: "http://site.com/foo", Text: "Foo"}}},
+		{"nested links", "<a href='/foo'>Foo<a href='/bar'>Bar</a></a>",
+			[]Link{{URL: "http://site.com/foo", Text: "Foo"}, {URL: "http://site.com/bar", Text: "Bar"}}},
+		{"duplicate links keep the first text", "<a href='/foo'>One</a><a href='/foo'>Two</a>", []Link{{URL: "http://site.com/foo", Text: "One"}}},
+	}
+	for _, tt := range testData {
+		tt := tt
+		t.Run(tt.testName, func(t *testing.T) {
+			links := SimpleLinkExtractor(baseURL, baseURL, strings.NewReader(tt.response)).Links
+			assert.Equal(t, tt.expectedLinks, links)
+		})
+	}
+}
+
+func TestSimpleLinkExtractorMedia(t *testing.T) {
+	baseURL := "http://site.com/docs/"
+	testData := []struct {
+		testName       string
+		response       string
+		expectedImages []Image
+		expectedVideos []Video
+	}{
+		{"img", "<img src='logo.png' alt=' Site  logo '><img src='/logo.png'>",
+			[]Image{{URL: "http://site.com/docs/logo.png", Alt: "Site logo"}, {URL: "http://site.com/logo.png"}}, nil},
+		{"img inside a link", "<a href='/'><img src='logo.png?v=2'></a>", []Image{{URL: "http://site.com/docs/logo.png?v=2"}}, nil},
+		{"srcset", "<img src='a.png' srcset='a.png 1x, b.png 2x'>",
+			[]Image{{URL: "http://site.com/docs/a.png"}, {URL: "http://site.com/docs/b.png"}}, nil},
+		{"picture", "<picture><source srcset='a.webp 480w,b.webp 1080w'><img src='a.jpg'></picture><source srcset='c.webp'>",
+			[]Image{{URL: "http://site.com/docs/a.webp"}, {URL: "http://site.com/docs/b.webp"}, {URL: "http://site.com/docs/a.jpg"}}, nil},
+		{"data uri", "<img src='data:image/png;base64,AAAA'><img src=''>", nil, nil},
+		{"video src", "<video src='intro.mp4' poster='intro.jpg' title='Intro'></video>",
+			nil, []Video{{URL: "http://site.com/docs/intro.mp4", ThumbnailURL: "http://site.com/docs/intro.jpg", Title: "Intro"}}},
+		{"video sources", "<video poster='/intro.jpg'><source src='intro.webm'><source src='intro.mp4'></video>",
+			nil, []Video{{URL: "http://site.com/docs/intro.webm", ThumbnailURL: "http://site.com/intro.jpg"}}},
+		{"base href", "<base href='http://cdn.site.com/'><img src='logo.png'><video src='intro.mp4'></video>",
+			[]Image{{URL: "http://cdn.site.com/logo.png"}}, []Video{{URL: "http://cdn.site.com/intro.mp4"}}},
+	}
+	for _, tt := range testData {
+		tt := tt
+		t.Run(tt.testName, func(t *testing.T) {
+			doc := SimpleLinkExtractor(baseURL, baseURL, strings.NewReader(tt.response))
+			assert.Equal(t, tt.expectedImages, doc.Images)
+			assert.Equal(t, tt.expectedVideos, doc.Videos)
+		})
+	}
+}
+
+func TestSimpleLinkExtractorAlternates(t *testing.T) {
+	response := `<head>
+		<link rel="alternate" hreflang="en" href="/en/">
+		<link rel="Alternate" hreflang="de" href="http://site.com/de/?utm=1" />
+		<link rel="alternate" href="/feed.xml" type="application/rss+xml">
+		<link rel="stylesheet" hreflang="fr" href="/fr.css">
+		<link rel="alternate" hreflang="x-default" href="/">
+	</head>`
+	doc := SimpleLinkExtractor("http://site.com", "http://site.com/en/", strings.NewReader(response))
+	assert.Equal(t, []Alternate{
+		{URL: "http://site.com/en/", Hreflang: "en"},
+		{URL: "http://site.com/de/", Hreflang: "de"},
+		{URL: "http://site.com/", Hreflang: "x-default"},
+	}, doc.Alternates)
+	// Alternates are not links
+	assert.Empty(t, doc.Links)
+}
+
+func TestSimpleLinkExtractorTitle(t *testing.T) {
+	doc := SimpleLinkExtractor("http://site.com", "http://site.com",
+		strings.NewReader("<html><head><title>\n  Foo &amp; Bar\n</title></head><body><svg><title>Icon</title></svg></body></html>"))
+	assert.Equal(t, "Foo & Bar", doc.Title)
+}
+
+func TestCheck(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/ok", func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, http.MethodHead, r.Method)
+	})
+	mux.HandleFunc("/missing", func(w http.ResponseWriter, r *http.Request) {
+		http.NotFound(w, r)
+	})
+	mux.HandleFunc("/no-head", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodHead {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		fmt.Fprint(w, "<a href='/foo'></a>")
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	f := NewSimpleFetcher(server.URL, WithIgnoreRobots())
+	f.client = server.Client()
+	// Ensure SimpleFetcher conforms to the LinkChecker interface
+	var _ LinkChecker = f
+
+	t.Run("success", func(t *testing.T) {
+		result := f.Check(context.Background(), server.URL+"/ok")
+		assert.Nil(t, result.Err)
+		assert.Equal(t, http.StatusOK, result.StatusCode)
+	})
+	t.Run("not found", func(t *testing.T) {
+		result := f.Check(context.Background(), server.URL+"/missing")
+		assert.Nil(t, result.Err)
+		assert.Equal(t, http.StatusNotFound, result.StatusCode)
+	})
+	t.Run("HEAD not allowed", func(t *testing.T) {
+		result := f.Check(context.Background(), server.URL+"/no-head")
+		assert.Nil(t, result.Err)
+		assert.Equal(t, http.StatusOK, result.StatusCode)
+		assert.Nil(t, result.Links)
+	})
+	t.Run("connection error", func(t *testing.T) {
+		result := f.Check(context.Background(), "http://localhost:0/")
+		assert.Error(t, result.Err)
+	})
+}
+
+// timeoutError is a net.Error which timed out
+type timeoutError struct{}
+
+func (timeoutError) Error() string   { return "i/o timeout" }
+func (timeoutError) Timeout() bool   { return true }
+func (timeoutError) Temporary() bool { return true }
+
+// flakyClient fails the first requests with the given error
+type flakyClient struct {
+	fakeClient
+	err      error
+	failures int
+	requests *int
+}
+
+func (fc flakyClient) Do(req *http.Request) (*http.Response, error) {
+	*fc.requests++
+	if *fc.requests <= fc.failures {
+		return nil, fc.err
+	}
+	return fc.fakeClient.Do(req)
+}
+
+func TestRetry(t *testing.T) {
+	var requests int
+	mux := http.NewServeMux()
+	mux.HandleFunc("/unavailable-once", func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		if requests == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		fmt.Fprint(w, "<a href='/child'></a>")
+	})
+	mux.HandleFunc("/down", func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		w.WriteHeader(http.StatusBadGateway)
+	})
+	mux.HandleFunc("/too-many", func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		w.Header().Set("Retry-After", "1")
+		w.WriteHeader(http.StatusTooManyRequests)
+	})
+	mux.HandleFunc("/not-implemented", func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		w.WriteHeader(http.StatusNotImplemented)
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	policy := RetryPolicy{MaxAttempts: 3, BaseDelay: time.Millisecond, MaxDelay: 10 * time.Millisecond}
+	f := NewSimpleFetcher(server.URL, WithIgnoreRobots(), WithRetryPolicy(policy))
+	f.client = server.Client()
+
+	t.Run("5xx", func(t *testing.T) {
+		requests = 0
+		result := f.Fetch(context.Background(), server.URL+"/unavailable-once", SimpleLinkExtractor)
+		assert.Nil(t, result.Err)
+		assert.Equal(t, http.StatusOK, result.StatusCode)
+		assert.Equal(t, 2, result.Attempts)
+		assert.Equal(t, []string{server.URL + "/child"}, LinkURLs(result.Links))
+	})
+	t.Run("max attempts", func(t *testing.T) {
+		requests = 0
+		result := f.Fetch(context.Background(), server.URL+"/down", SimpleLinkExtractor)
+		assert.Nil(t, result.Err)
+		assert.Equal(t, http.StatusBadGateway, result.StatusCode)
+		assert.Equal(t, 3, result.Attempts)
+		assert.Equal(t, 3, requests)
+	})
+	t.Run("Retry-After longer than max delay", func(t *testing.T) {
+		requests = 0
+		result := f.Check(context.Background(), server.URL+"/too-many")
+		assert.Equal(t, http.StatusTooManyRequests, result.StatusCode)
+		assert.Equal(t, 1, result.Attempts)
+	})
+	t.Run("Retry-After", func(t *testing.T) {
+		requests = 0
+		f := NewSimpleFetcher(server.URL, WithIgnoreRobots(), WithRetryPolicy(RetryPolicy{MaxAttempts: 2, MaxDelay: 5 * time.Second}))
+		f.client = server.Client()
+		start := time.Now()
+		result := f.Check(context.Background(), server.URL+"/too-many")
+		assert.Equal(t, 2, result.Attempts)
+		assert.True(t, time.Since(start) >= time.Second)
+	})
+	t.Run("not retryable", func(t *testing.T) {
+		requests = 0
+		result := f.Fetch(context.Background(), server.URL+"/not-implemented", SimpleLinkExtractor)
+		assert.Equal(t, http.StatusNotImplemented, result.StatusCode)
+		assert.Equal(t, 1, result.Attempts)
+	})
+	t.Run("disabled", func(t *testing.T) {
+		requests = 0
+		f := NewSimpleFetcher(server.URL, WithIgnoreRobots(), WithRetryPolicy(RetryPolicy{}))
+		f.client = server.Client()
+		result := f.Fetch(context.Background(), server.URL+"/down", SimpleLinkExtractor)
+		assert.Equal(t, 1, result.Attempts)
+	})
+
+	client := fakeClient{responseCache: map[string]string{"http://foo.com/": "<a href='/bar'></a>"}}
+	t.Run("timeout", func(t *testing.T) {
+		f := NewSimpleFetcher("http://foo.com/", WithIgnoreRobots(), WithRetryPolicy(policy))
+		f.client = flakyClient{fakeClient: client, err: timeoutError{}, failures: 2, requests: new(int)}
+		result := f.Fetch(context.Background(), "http://foo.com/", SimpleLinkExtractor)
+		assert.Nil(t, result.Err)
+		assert.Equal(t, 3, result.Attempts)
+		assert.Equal(t, []string{"http://foo.com/bar"}, LinkURLs(result.Links))
+	})
+	t.Run("connection reset", func(t *testing.T) {
+		f := NewSimpleFetcher("http://foo.com/", WithIgnoreRobots(), WithRetryPolicy(policy))
+		f.client = flakyClient{fakeClient: client, err: &net.OpError{Op: "read", Err: syscall.ECONNRESET}, failures: 3, requests: new(int)}
+		result := f.Fetch(context.Background(), "http://foo.com/", SimpleLinkExtractor)
+		assert.Error(t, result.Err)
+		assert.True(t, errors.Is(result.Err, syscall.ECONNRESET))
+		assert.Equal(t, 3, result.Attempts)
+	})
+	t.Run("other errors", func(t *testing.T) {
+		f := NewSimpleFetcher("http://foo.com/", WithIgnoreRobots(), WithRetryPolicy(policy))
+		f.client = flakyClient{fakeClient: client, err: errors.New("invalid URL"), failures: 1, requests: new(int)}
+		result := f.Fetch(context.Background(), "http://foo.com/", SimpleLinkExtractor)
+		assert.Error(t, result.Err)
+		assert.Equal(t, 1, result.Attempts)
+	})
+	t.Run("canceled during backoff", func(t *testing.T) {
+		f := NewSimpleFetcher("http://foo.com/", WithIgnoreRobots(), WithRetryPolicy(RetryPolicy{MaxAttempts: 3, BaseDelay: time.Hour}))
+		f.client = flakyClient{fakeClient: client, err: timeoutError{}, failures: 3, requests: new(int)}
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+		defer cancel()
+		result := f.Fetch(ctx, "http://foo.com/", SimpleLinkExtractor)
+		assert.True(t, errors.Is(result.Err, context.DeadlineExceeded))
+		assert.Equal(t, 1, result.Attempts)
+	})
+}
+
+func TestNewHTTPClient(t *testing.T) {
+	t.Run("defaults", func(t *testing.T) {
+		f := NewSimpleFetcher("http://foo.com/")
+		client := f.client.(*http.Client)
+		assert.NotSame(t, http.DefaultClient, client)
+		assert.Equal(t, time.Duration(0), http.DefaultClient.Timeout)
+		assert.Equal(t, 5*time.Second, client.Timeout)
+	})
+	t.Run("transport", func(t *testing.T) {
+		client, err := NewHTTPClient(ClientConfig{
+			Timeout:             time.Minute,
+			ReadTimeout:         10 * time.Second,
+			MaxIdleConnsPerHost: 20,
+			Proxy:               "socks5://proxy:1080",
+		})
+		assert.Nil(t, err)
+		assert.Equal(t, time.Minute, client.Timeout)
+		transport := client.Transport.(*http.Transport)
+		assert.Equal(t, 10*time.Second, transport.ResponseHeaderTimeout)
+		assert.Equal(t, 20, transport.MaxIdleConnsPerHost)
+		req, _ := http.NewRequest(http.MethodGet, "http://foo.com/", nil)
+		proxy, err := transport.Proxy(req)
+		assert.Nil(t, err)
+		assert.Equal(t, "socks5://proxy:1080", proxy.String())
+	})
+	t.Run("invalid config", func(t *testing.T) {
+		dir := t.TempDir()
+		empty := filepath.Join(dir, "empty.pem")
+		assert.Nil(t, os.WriteFile(empty, nil, 0600))
+		for _, config := range []ClientConfig{
+			{Proxy: "ftp://proxy"},
+			{Proxy: "http://[::1"},
+			{CAFile: filepath.Join(dir, "missing.pem")},
+			{CAFile: empty},
+			{CertFile: empty},
+			{CertFile: empty, KeyFile: empty},
+		} {
+			_, err := NewHTTPClient(config)
+			assert.Error(t, err, "%+v", config)
+		}
+	})
+
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, r.Proto)
+	})
+	server := httptest.NewUnstartedServer(handler)
+	server.EnableHTTP2 = true
+	server.StartTLS()
+	defer server.Close()
+	get := func(t *testing.T, config ClientConfig) (string, error) {
+		client, err := NewHTTPClient(config)
+		assert.Nil(t, err)
+		resp, err := client.Get(server.URL)
+		if err != nil {
+			return "", err
+		}
+		defer resp.Body.Close()
+		body, err := ioutil.ReadAll(resp.Body)
+		return string(body), err
+	}
+
+	t.Run("TLS", func(t *testing.T) {
+		_, err := get(t, ClientConfig{})
+		assert.Error(t, err)
+
+		caFile := filepath.Join(t.TempDir(), "ca.pem")
+		assert.Nil(t, os.WriteFile(caFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw}), 0600))
+		proto, err := get(t, ClientConfig{CAFile: caFile})
+		assert.Nil(t, err)
+		assert.Equal(t, "HTTP/2.0", proto)
+
+		proto, err = get(t, ClientConfig{InsecureSkipVerify: true, DisableHTTP2: true})
+		assert.Nil(t, err)
+		assert.Equal(t, "HTTP/1.1", proto)
+	})
+	t.Run("client certificate", func(t *testing.T) {
+		server := httptest.NewUnstartedServer(handler)
+		server.TLS = &tls.Config{ClientAuth: tls.RequireAnyClientCert}
+		server.StartTLS()
+		defer server.Close()
+
+		certFile, keyFile := writeKeyPair(t)
+		for _, config := range []ClientConfig{
+			{InsecureSkipVerify: true},
+			{InsecureSkipVerify: true, CertFile: certFile, KeyFile: keyFile},
+		} {
+			client, err := NewHTTPClient(config)
+			assert.Nil(t, err)
+			resp, err := client.Get(server.URL)
+			if config.CertFile == "" {
+				assert.Error(t, err)
+			} else if assert.Nil(t, err) {
+				resp.Body.Close()
+				assert.Equal(t, http.StatusOK, resp.StatusCode)
+			}
+		}
+	})
+}
+
+// writeKeyPair writes a self-signed certificate and its key to PEM files.
+// Returns the paths of the files.
+func writeKeyPair(t *testing.T) (string, string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.Nil(t, err)
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "webcrawler"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	assert.Nil(t, err)
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	assert.Nil(t, err)
+
+	dir := t.TempDir()
+	certFile, keyFile := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	assert.Nil(t, os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600))
+	assert.Nil(t, os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600))
+	return certFile, keyFile
+}
+
+func TestRetryPolicyBackoff(t *testing.T) {
+	policy := RetryPolicy{BaseDelay: 100 * time.Millisecond, MaxDelay: time.Second}
+	assert.Equal(t, 100*time.Millisecond, policy.backoff(1))
+	assert.Equal(t, 200*time.Millisecond, policy.backoff(2))
+	assert.Equal(t, 800*time.Millisecond, policy.backoff(4))
+	assert.Equal(t, time.Second, policy.backoff(5))
+	assert.Equal(t, time.Second, policy.backoff(100))
+
+	policy.Jitter = 50 * time.Millisecond
+	for i := 0; i < 10; i++ {
+		delay := policy.backoff(1)
+		assert.True(t, delay >= 100*time.Millisecond && delay < 150*time.Millisecond, delay)
+	}
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	now := time.Date(2020, 1, 2, 15, 4, 5, 0, time.UTC)
+	testData := []struct {
+		value    string
+		expected time.Duration
+		ok       bool
+	}{
+		{"", 0, false},
+		{"120", 2 * time.Minute, true},
+		{"-1", 0, false},
+		{"Thu, 02 Jan 2020 15:04:35 GMT", 30 * time.Second, true},
+		{"Thu, 02 Jan 2020 15:00:00 GMT", 0, true},
+		{"soon", 0, false},
+	}
+	for _, tt := range testData {
+		delay, ok := parseRetryAfter(tt.value, now)
+		assert.Equal(t, tt.ok, ok, tt.value)
+		assert.Equal(t, tt.expected, delay, tt.value)
+	}
+}
+
+func TestSimpleLinkExtractorRelativeLinks(t *testing.T) {
+	baseURL := "http://site.com"
+	currentURL := "http://site.com/docs/guide/"
+	testData := []struct {
+		testName        string
+		response        string
+		expectedURLList []string
+	}{
+		{"relative to current page", "<a href='child.html'></a>", []string{"http://site.com/docs/guide/child.html"}},
+		{"dot segment", "<a href='./child.html'></a>", []string{"http://site.com/docs/guide/child.html"}},
+		{"parent directory", "<a href='../other/'></a>", []string{"http://site.com/docs/other/"}},
+		{"absolute path", "<a href='/about'></a>", []string{"http://site.com/about"}},
+		{"network path", "<a href='//cdn.site.com/x'></a>", []string{"http://cdn.site.com/x"}},
+		{"current directory", "<a href='.'></a>", nil},
+		{"fragment of current page", "<a href='#top'></a>", nil},
+		{"base href", "<head><base href='/static/'></head><a href='child.html'></a>",
+			[]string{"http://site.com/static/child.html"}},
+		{"self closing base href", "<head><base href='http://cdn.com/v1/' /></head><a href='a/b'></a>",
+			[]string{"http://cdn.com/v1/a/b"}},
+		{"relative base href", "<base href='../'><a href='child.html'></a>",
+			[]string{"http://site.com/docs/child.html"}},
+		{"only the first base href is used", "<base href='/one/'><base href='/two/'><a href='child.html'></a>",
+			[]string{"http://site.com/one/child.html"}},
+		{"base without href", "<base target='_blank'><base href='/one/'><a href='child.html'></a>",
+			[]string{"http://site.com/one/child.html"}},
+	}
+
+	for _, tt := range testData {
+		tt := tt
+		t.Run(tt.testName, func(t *testing.T) {
+			body := strings.NewReader(tt.response)
+			actualURLList := LinkURLs(SimpleLinkExtractor(baseURL, currentURL, body).Links)
+			assert.Equal(t, tt.expectedURLList, actualURLList)
+		})
+	}
+}
+
+func TestHeaders(t *testing.T) {
+	var mu sync.Mutex
+	received := make(map[string]http.Header)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		received[r.Method+" "+r.URL.Path] = r.Header
+		mu.Unlock()
+		fmt.Fprint(w, "<html></html>")
+	}))
+	defer server.Close()
+
+	f := NewSimpleFetcher(server.URL,
+		WithClient(server.Client()),
+		WithUserAgent("Mozilla/5.0 (compatible; webcrawler/1.0)"),
+		WithHeader(http.Header{"X-Crawler": {"true"}, "User-Agent": {"ignored"}}),
+		WithAcceptLanguage("fr-FR, fr;q=0.9"),
+	)
+	page := f.Fetch(context.Background(), server.URL+"/page", SimpleLinkExtractor)
+	assert.Nil(t, page.Err)
+	page = f.Check(context.Background(), server.URL+"/check")
+	assert.Nil(t, page.Err)
+
+	for _, request := range []string{"GET /robots.txt", "GET /page", "HEAD /check"} {
+		header := received[request]
+		if assert.NotNil(t, header, request) {
+			assert.Equal(t, []string{"Mozilla/5.0 (compatible; webcrawler/1.0)"}, header["User-Agent"], request)
+			assert.Equal(t, "true", header.Get("X-Crawler"), request)
+			assert.Equal(t, "fr-FR, fr;q=0.9", header.Get("Accept-Language"), request)
+		}
+	}
+}
+
+func TestParseHeader(t *testing.T) {
+	testData := []struct {
+		name          string
+		value         string
+		expectedName  string
+		expectedValue string
+		expectError   bool
+	}{
+		{"header", "X-Crawler: true", "X-Crawler", "true", false},
+		{"canonical name", "accept-language:fr-FR, fr;q=0.9 ", "Accept-Language", "fr-FR, fr;q=0.9", false},
+		{"empty value", "X-Empty:", "X-Empty", "", false},
+		{"missing colon", "X-Crawler true", "", "", true},
+		{"missing name", ": true", "", "", true},
+		{"invalid name", "X Crawler: true", "", "", true},
+		{"invalid value", "X-Crawler: a\x00b", "", "", true},
+	}
+	for _, tt := range testData {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			name, value, err := ParseHeader(tt.value)
+			if tt.expectError {
+				assert.Error(t, err)
+			} else {
+				assert.Nil(t, err)
+			}
+			assert.Equal(t, tt.expectedName, name)
+			assert.Equal(t, tt.expectedValue, value)
+		})
+	}
+}
+
+func TestReadHeaders(t *testing.T) {
+	header, err := ReadHeaders(strings.NewReader(`
+# Sent with every request
+User-Agent: Mozilla/5.0 (compatible; webcrawler/1.0)
+
+x-crawler: true
+X-Crawler: again
+`))
+	assert.Nil(t, err)
+	assert.Equal(t, http.Header{
+		"User-Agent": {"Mozilla/5.0 (compatible; webcrawler/1.0)"},
+		"X-Crawler":  {"true", "again"},
+	}, header)
+
+	_, err = ReadHeaders(strings.NewReader("X-Crawler: true\ninvalid\n"))
+	assert.EqualError(t, err, `line 2: invalid header "invalid": expected Name: value`)
+}
+
+func TestParseHostAuth(t *testing.T) {
+	testData := []struct {
+		name        string
+		value       string
+		expected    HostAuth
+		expectError bool
+	}{
+		{"basic auth", "staging.example.com,user=alice,password=a=b", HostAuth{Pattern: "staging.example.com", Username: "alice", Password: "a=b"}, false},
+		{"empty password", "*.example.com,user=alice", HostAuth{Pattern: "*.example.com", Username: "alice"}, false},
+		{"token", "*,token=secret", HostAuth{Pattern: "*", Token: "secret"}, false},
+		{"missing pattern", ",token=secret", HostAuth{}, true},
+		{"invalid pattern", "[,token=secret", HostAuth{}, true},
+		{"missing credentials", "example.com", HostAuth{}, true},
+		{"password only", "example.com,password=secret", HostAuth{}, true},
+		{"user and token", "example.com,user=alice,token=secret", HostAuth{}, true},
+		{"unknown key", "example.com,pass=secret", HostAuth{}, true},
+		{"missing value", "example.com,secret", HostAuth{}, true},
+	}
+	for _, tt := range testData {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			auth, err := ParseHostAuth(tt.value)
+			if tt.expectError {
+				assert.Error(t, err)
+				assert.NotContains(t, err.Error(), "secret")
+			} else {
+				assert.Nil(t, err)
+			}
+			assert.Equal(t, tt.expected, auth)
+		})
+	}
+}
+
+func TestAuth(t *testing.T) {
+	var mu sync.Mutex
+	received := make(map[string]string)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		received[r.Host] = r.Header.Get("Authorization")
+		mu.Unlock()
+	}))
+	defer server.Close()
+
+	u, _ := url.Parse(server.URL)
+	f := NewSimpleFetcher(server.URL,
+		WithClient(server.Client()),
+		WithIgnoreRobots(),
+		WithAuth(
+			HostAuth{Pattern: "127.0.0.1", Username: "alice", Password: "secret"},
+			HostAuth{Pattern: "*", Token: "token"},
+			HostAuth{Pattern: "localhost", Username: "ignored"},
+		),
+	)
+	f.Check(context.Background(), "http://127.0.0.1:"+u.Port()+"/")
+	f.Check(context.Background(), "http://localhost:"+u.Port()+"/")
+
+	assert.Equal(t, map[string]string{
+		"127.0.0.1:" + u.Port(): "Basic YWxpY2U6c2VjcmV0",
+		"localhost:" + u.Port(): "Bearer token",
+	}, received)
+}
+
+func TestGet(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, "%s %s", r.UserAgent(), r.Header.Get("Authorization"))
+	}))
+	defer server.Close()
+
+	f := NewSimpleFetcher(server.URL,
+		WithClient(server.Client()),
+		WithUserAgent("testbot"),
+		WithAuth(HostAuth{Pattern: "*", Token: "token"}),
+	)
+	resp, err := f.Get(context.Background(), server.URL+"/sitemap.xml")
+	assert.Nil(t, err)
+	defer resp.Body.Close()
+	body, _ := ioutil.ReadAll(resp.Body)
+	assert.Equal(t, "testbot Bearer token", string(body))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = f.Get(ctx, server.URL+"/sitemap.xml")
+	assert.ErrorIs(t, err, context.Canceled)
+}
+
+func TestLogin(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/login", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.PostFormValue("username") != "alice" || r.PostFormValue("password") != "secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		http.SetCookie(w, &http.Cookie{Name: "session", Value: "42", Path: "/", HttpOnly: true})
+		http.Redirect(w, r, "/", http.StatusFound)
+	})
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		if cookie, err := r.Cookie("session"); err != nil || cookie.Value != "42" {
+			w.WriteHeader(http.StatusForbidden)
+		}
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	newFetcher := func() *SimpleFetcher {
+		client, err := NewHTTPClient(ClientConfig{MaxRedirects: DefaultMaxRedirects, Jar: NewCookieJar()})
+		assert.Nil(t, err)
+		return NewSimpleFetcher(server.URL, WithClient(client), WithIgnoreRobots())
+	}
+
+	t.Run("success", func(t *testing.T) {
+		f := newFetcher()
+		err := f.Login(context.Background(), server.URL+"/login", url.Values{"username": {"alice"}, "password": {"secret"}})
+		assert.Nil(t, err)
+		page := f.Fetch(context.Background(), server.URL+"/private", SimpleLinkExtractor)
+		assert.Equal(t, http.StatusOK, page.StatusCode)
+	})
+	t.Run("invalid credentials", func(t *testing.T) {
+		f := newFetcher()
+		err := f.Login(context.Background(), server.URL+"/login", url.Values{"username": {"alice"}, "password": {"wrong"}})
+		assert.EqualError(t, err, "login failed: "+server.URL+"/login returned status 401")
+		page := f.Fetch(context.Background(), server.URL+"/private", SimpleLinkExtractor)
+		assert.Equal(t, http.StatusForbidden, page.StatusCode)
+	})
+}
+
+func TestCookieJar(t *testing.T) {
+	expiry := time.Now().Add(time.Hour).Unix()
+	file := fmt.Sprintf(`# Netscape HTTP Cookie File
+# Comment
+
+.example.com	TRUE	/	FALSE	%[1]d	theme	dark
+#HttpOnly_staging.example.com	FALSE	/	TRUE	0	session	42
+staging.example.com	FALSE	/docs	FALSE	%[1]d	lang	
+example.com	FALSE	/	FALSE	1	expired	yes
+`, expiry)
+	jar := NewCookieJar()
+	assert.Nil(t, jar.Load(strings.NewReader(file)))
+
+	cookieNames := func(rawURL string) []string {
+		u, _ := url.Parse(rawURL)
+		var names []string
+		for _, cookie := range jar.Cookies(u) {
+			names = append(names, cookie.Name+"="+cookie.Value)
+		}
+		sort.Strings(names)
+		return names
+	}
+	assert.Equal(t, []string{"lang=", "session=42", "theme=dark"}, cookieNames("https://staging.example.com/docs/page"))
+	assert.Equal(t, []string{"theme=dark"}, cookieNames("http://staging.example.com/"))
+	assert.Equal(t, []string{"theme=dark"}, cookieNames("https://www.example.com/"))
+
+	// The cookies set by the responses are saved along with the loaded ones
+	u, _ := url.Parse("http://www.example.com/blog/post")
+	jar.SetCookies(u, []*http.Cookie{
+		{Name: "visited", Value: "1", Expires: time.Unix(expiry, 0)},
+		{Name: "theme", Value: "light", Domain: ".example.com", Path: "/"},
+		{Name: "other", Value: "1", Domain: "other.com"},
+	})
+	u, _ = url.Parse("http://staging.example.com/docs/")
+	jar.SetCookies(u, []*http.Cookie{{Name: "lang", MaxAge: -1}})
+
+	var buf bytes.Buffer
+	assert.Nil(t, jar.Save(&buf))
+	assert.Equal(t, fmt.Sprintf(`# Netscape HTTP Cookie File
+.example.com	TRUE	/	FALSE	0	theme	light
+#HttpOnly_staging.example.com	FALSE	/	TRUE	0	session	42
+www.example.com	FALSE	/blog	FALSE	%d	visited	1
+`, expiry), buf.String())
+
+	// The saved file is loaded back
+	reloaded := NewCookieJar()
+	assert.Nil(t, reloaded.Load(&buf))
+	u, _ = url.Parse("https://staging.example.com/")
+	assert.Len(t, reloaded.Cookies(u), 2)
+
+	err := NewCookieJar().Load(strings.NewReader("example.com\tFALSE\t/\n"))
+	assert.EqualError(t, err, "line 1: expected 7 tab separated fields, got 3")
+	err = NewCookieJar().Load(strings.NewReader("example.com\tFALSE\t/\tFALSE\tnever\tname\tvalue\n"))
+	assert.EqualError(t, err, `line 1: invalid expiry "never"`)
+}
+
+func TestParseHostLimit(t *testing.T) {
+	testData := []struct {
+		name        string
+		value       string
+		expected    HostLimit
+		expectError bool
+	}{
+		{"pattern only", "example.com", HostLimit{Pattern: "example.com"}, false},
+		{"all fields", "*.example.com,rps=2,delay=500ms,jitter=100ms",
+			HostLimit{Pattern: "*.example.com", RequestsPerSecond: 2, MinDelay: 500 * time.Millisecond, Jitter: 100 * time.Millisecond}, false},
+		{"missing pattern", ",rps=2", HostLimit{}, true},
+		{"invalid pattern", "[,rps=2", HostLimit{}, true},
+		{"invalid rps", "*,rps=fast", HostLimit{}, true},
+		{"invalid delay", "*,delay=5", HostLimit{}, true},
+		{"unknown key", "*,burst=5", HostLimit{}, true},
+		{"missing value", "*,rps", HostLimit{}, true},
+	}
+	for _, tt := range testData {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			limit, err := ParseHostLimit(tt.value)
+			if tt.expectError {
+				assert.Error(t, err)
+			} else {
+				assert.Nil(t, err)
+			}
+			assert.Equal(t, tt.expected, limit)
+		})
+	}
+}
+
+func TestRateLimiter(t *testing.T) {
+	limiter := NewRateLimiter(
+		HostLimit{Pattern: "slow.com", MinDelay: 50 * time.Millisecond},
+		HostLimit{Pattern: "*.fast.com", RequestsPerSecond: 100},
+	)
+	// waitN calls limiter.Wait n times and returns the time taken
+	waitN := func(t *testing.T, host string, n int) time.Duration {
+		start := time.Now()
+		for i := 0; i < n; i++ {
+			assert.Nil(t, limiter.Wait(context.Background(), host))
+		}
+		return time.Since(start)
+	}
+	t.Run("min delay", func(t *testing.T) {
+		assert.True(t, waitN(t, "slow.com", 3) >= 100*time.Millisecond)
+	})
+	t.Run("requests per second", func(t *testing.T) {
+		assert.True(t, waitN(t, "www.fast.com", 3) >= 20*time.Millisecond)
+	})
+	t.Run("hosts are limited separately", func(t *testing.T) {
+		waitN(t, "a.fast.com", 1)
+		assert.True(t, waitN(t, "b.fast.com", 1) < 10*time.Millisecond)
+	})
+	t.Run("no matching limit", func(t *testing.T) {
+		assert.True(t, waitN(t, "other.com", 10) < 10*time.Millisecond)
+	})
+	t.Run("canceled context", func(t *testing.T) {
+		waitN(t, "slow.com", 1)
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		assert.Equal(t, context.Canceled, limiter.Wait(ctx, "slow.com"))
+	})
+}
+
+func TestParseRobots(t *testing.T) {
+	robotsTxt := `
+# comment
+User-agent: *
+Disallow: /private/
+Allow: /private/public/
+Disallow: /*.pdf$
+Disallow: /search?*q=
+
+User-agent: webcrawler
+User-agent: otherbot
+Disallow: /no-crawler/
+Crawl-delay: 1.5
+
+User-agent: badbot
+Disallow: /
+
+User-agent: mozilla
+Disallow: /browsers/
+`
+	testData := []struct {
+		name      string
+		userAgent string
+		path      string
+		allowed   bool
+	}{
+		{"no matching rule", "somebot", "/foo", true},
+		{"disallowed prefix", "somebot", "/private/foo", false},
+		{"longest rule wins", "somebot", "/private/public/foo", true},
+		{"wildcard with end anchor", "somebot", "/docs/file.pdf", false},
+		{"end anchor doesn't match longer path", "somebot", "/docs/file.pdf.html", true},
+		{"wildcard in query", "somebot", "/search?lang=en&q=go", false},
+		{"query without match", "somebot", "/search?lang=en", true},
+		{"specific group", "webcrawler/1.0", "/no-crawler/page", false},
+		{"specific group ignores * group", "webcrawler/1.0", "/private/foo", true},
+		{"user agent match is case insensitive", "WebCrawler", "/no-crawler/page", false},
+		{"disallow all", "badbot", "/", false},
+		{"product token in comment", "Mozilla/5.0 (compatible; webcrawler/1.0; +https://g.org/bot)", "/no-crawler/page", false},
+		{"browser group doesn't match compatible crawler", "Mozilla/5.0 (compatible; webcrawler/1.0)", "/browsers/", true},
+		{"browser user agent", "Mozilla/5.0 (X11; Linux x86_64)", "/browsers/", false},
+		{"product token must match exactly", "superwebcrawler/2.0", "/no-crawler/page", true},
+	}
+	for _, tt := range testData {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			rules := parseRobots(strings.NewReader(robotsTxt), tt.userAgent)
+			assert.Equal(t, tt.allowed, rules.allowed(tt.path))
+		})
+	}
+	t.Run("crawl delay", func(t *testing.T) {
+		assert.Equal(t, 1500*time.Millisecond, parseRobots(strings.NewReader(robotsTxt), "otherbot").crawlDelay)
+		assert.Equal(t, time.Duration(0), parseRobots(strings.NewReader(robotsTxt), "somebot").crawlDelay)
+	})
+	t.Run("empty disallow", func(t *testing.T) {
+		rules := parseRobots(strings.NewReader("User-agent: *\nDisallow:\n"), "somebot")
+		assert.True(t, rules.allowed("/foo"))
+	})
+}
+
+func TestRobotsProductToken(t *testing.T) {
+	testData := []struct {
+		userAgent string
+		expected  string
+	}{
+		{"webcrawler", "webcrawler"},
+		{"WebCrawler/1.0", "webcrawler"},
+		{"webcrawler/1.0 (+https://g.org/bot)", "webcrawler"},
+		{"Mozilla/5.0 (compatible; Googlebot/2.1; +http://www.google.com/bot.html)", "googlebot"},
+		{"Mozilla/5.0 (X11; Linux x86_64)", "mozilla"},
+	}
+	for _, tt := range testData {
+		assert.Equal(t, tt.expected, robotsProductToken(tt.userAgent), tt.userAgent)
+	}
+}
+
+func TestFetchRobots(t *testing.T) {
+	robotsClient := fakeClient{
+		responseCache: map[string]string{
+			"http://localhost:8000/robots.txt":   "User-agent: *\nDisallow: /private\nCrawl-delay: 2",
+			"http://localhost:8000/private/page": "<a href='/foo'></a>",
+			"http://localhost:8000/public/page":  "<a href='/foo'></a>",
+		},
+	}
+	t.Run("disallowed URL", func(t *testing.T) {
+		f := NewSimpleFetcher("http://localhost:8000/")
+		f.client = robotsClient
+		result := f.Fetch(context.Background(), "http://localhost:8000/private/page", SimpleLinkExtractor)
+		assert.Nil(t, result.Links)
+		assert.Equal(t, ErrDisallowedByRobots, result.Err)
+	})
+	t.Run("allowed URL", func(t *testing.T) {
+		f := NewSimpleFetcher("http://localhost:8000/")
+		f.client = robotsClient
+		result := f.Fetch(context.Background(), "http://localhost:8000/public/page", SimpleLinkExtractor)
+		assert.Nil(t, result.Err)
+		assert.Equal(t, []string{"http://localhost:8000/foo"}, LinkURLs(result.Links))
+		// Crawl-delay is passed on to the rate limiter
+		assert.Equal(t, 2*time.Second, f.limiter.crawlDelays["localhost"])
+	})
+	t.Run("ignore robots", func(t *testing.T) {
+		f := NewSimpleFetcher("http://localhost:8000/", WithIgnoreRobots())
+		f.client = robotsClient
+		result := f.Fetch(context.Background(), "http://localhost:8000/private/page", SimpleLinkExtractor)
+		assert.Nil(t, result.Err)
+		assert.Equal(t, []string{"http://localhost:8000/foo"}, LinkURLs(result.Links))
+	})
+	t.Run("server error", func(t *testing.T) {
+		var mu sync.Mutex
+		robotsRequests := 0
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			switch r.URL.Path {
+			case "/robots.txt":
+				mu.Lock()
+				robotsRequests++
+				mu.Unlock()
+				w.WriteHeader(http.StatusServiceUnavailable)
+			default:
+				fmt.Fprint(w, "<html></html>")
+			}
+		}))
+		defer server.Close()
+
+		f := NewSimpleFetcher(server.URL, WithClient(server.Client()), WithRetryPolicy(RetryPolicy{MaxAttempts: 2}))
+		// robots.txt is retried and the host is disallowed once it still fails
+		result := f.Fetch(context.Background(), server.URL+"/page", SimpleLinkExtractor)
+		assert.Equal(t, ErrDisallowedByRobots, result.Err)
+		mu.Lock()
+		assert.Equal(t, 2, robotsRequests)
+		mu.Unlock()
+	})
+	t.Run("client error", func(t *testing.T) {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path == "/robots.txt" {
+				w.WriteHeader(http.StatusForbidden)
+				return
+			}
+			fmt.Fprint(w, "<html></html>")
+		}))
+		defer server.Close()
+
+		f := NewSimpleFetcher(server.URL, WithClient(server.Client()))
+		result := f.Fetch(context.Background(), server.URL+"/page", SimpleLinkExtractor)
+		assert.Nil(t, result.Err)
+	})
+	t.Run("missing robots.txt", func(t *testing.T) {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path == "/robots.txt" {
+				http.NotFound(w, r)
+				return
+			}
+			fmt.Fprint(w, "<a href='/foo'></a>")
+		}))
+		defer server.Close()
+
+		f := NewSimpleFetcher(server.URL, WithClient(server.Client()))
+		result := f.Fetch(context.Background(), server.URL+"/private/page", SimpleLinkExtractor)
+		assert.Nil(t, result.Err)
+	})
+	t.Run("unreachable robots.txt", func(t *testing.T) {
+		server := httptest.NewServer(http.NotFoundHandler())
+		server.Close()
+
+		f := NewSimpleFetcher(server.URL, WithClient(server.Client()), WithRetryPolicy(RetryPolicy{MaxAttempts: 1}))
+		result := f.Fetch(context.Background(), server.URL+"/page", SimpleLinkExtractor)
+		assert.Equal(t, ErrDisallowedByRobots, result.Err)
+	})
+	t.Run("aborted download", func(t *testing.T) {
+		var mu sync.Mutex
+		robotsRequests := 0
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path == "/robots.txt" {
+				mu.Lock()
+				robotsRequests++
+				mu.Unlock()
+				http.NotFound(w, r)
+				return
+			}
+			fmt.Fprint(w, "<html></html>")
+		}))
+		defer server.Close()
+
+		f := NewSimpleFetcher(server.URL, WithClient(server.Client()))
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		result := f.Fetch(ctx, server.URL+"/page", SimpleLinkExtractor)
+		assert.NotNil(t, result.Err)
+		// The rules of the aborted download are not cached
+		result = f.Fetch(context.Background(), server.URL+"/page", SimpleLinkExtractor)
+		assert.Nil(t, result.Err)
+		mu.Lock()
+		assert.Equal(t, 1, robotsRequests)
+		mu.Unlock()
+	})
+}
@@ -0,0 +1,52 @@
+package fetchers
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"golang.org/x/net/http/httpguts"
+)
+
+// ParseHeader parses a header of the form "Name: value". Returns the
+// canonical name of the header along with its value.
+func ParseHeader(s string) (string, string, error) {
+	kv := strings.SplitN(s, ":", 2)
+	if len(kv) != 2 {
+		return "", "", fmt.Errorf("invalid header %q: expected Name: value", s)
+	}
+	name, value := strings.TrimSpace(kv[0]), strings.TrimSpace(kv[1])
+	if !httpguts.ValidHeaderFieldName(name) {
+		return "", "", fmt.Errorf("invalid header %q: invalid name %q", s, name)
+	}
+	if !httpguts.ValidHeaderFieldValue(value) {
+		return "", "", fmt.Errorf("invalid header %q: invalid value", s)
+	}
+	return http.CanonicalHeaderKey(name), value, nil
+}
+
+// ReadHeaders reads a header file with one "Name: value" header per line.
+// Empty lines and lines starting with # are ignored. Sample file
+//
+//	# Sent with every request
+//	User-Agent: Mozilla/5.0 (compatible; webcrawler/1.0)
+//	Accept-Language: fr-FR, fr;q=0.9
+//	X-Crawler: true
+func ReadHeaders(r io.Reader) (http.Header, error) {
+	header := make(http.Header)
+	scanner := bufio.NewScanner(r)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		name, value, err := ParseHeader(text)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+		header.Add(name, value)
+	}
+	return header, scanner.Err()
+}
@@ -0,0 +1,133 @@
+package fetchers
+
+import (
+	"net/url"
+	"strings"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// Image is an image found on a page
+type Image struct {
+	URL string // URL is the absolute URL of the image
+	Alt string // Alt is the alt text of the image
+}
+
+// Video is a video found on a page
+type Video struct {
+	URL          string // URL is the absolute URL of the video file. Empty if the video has no source
+	ThumbnailURL string // ThumbnailURL is the absolute URL of the poster image of the video
+	Title        string // Title is the title attribute of the video
+}
+
+// mediaCollector collects the images and the videos of a page while it is
+// tokenized
+type mediaCollector struct {
+	images    []Image
+	videos    []Video
+	seen      map[string]struct{} // seen is used to ensure images are unique
+	openVideo int                 // openVideo is the index of the <video> being read, -1 otherwise
+	inPicture bool                // inPicture is set while a <picture> is read
+}
+
+func newMediaCollector() *mediaCollector {
+	return &mediaCollector{seen: make(map[string]struct{}), openVideo: -1}
+}
+
+// startTag collects the media of a start tag. Relative URLs are resolved
+// against baseURL.
+// <img src>, <img srcset> and <source srcset> inside <picture> are images.
+// <video src> and the first <source src> inside <video> are videos.
+func (m *mediaCollector) startTag(token html.Token, baseURL string) {
+	switch token.DataAtom {
+	case atom.Img:
+		alt, _ := findAttrValue(token, "alt")
+		if src, ok := findAttrValue(token, "src"); ok {
+			m.addImage(baseURL, src, alt)
+		}
+		if srcset, ok := findAttrValue(token, "srcset"); ok {
+			for _, src := range parseSrcset(srcset) {
+				m.addImage(baseURL, src, alt)
+			}
+		}
+	case atom.Picture:
+		m.inPicture = true
+	case atom.Source:
+		if m.openVideo >= 0 {
+			video := &m.videos[m.openVideo]
+			if src, ok := findAttrValue(token, "src"); ok && video.URL == "" {
+				video.URL, _ = resolveMediaURL(baseURL, src)
+			}
+		} else if srcset, ok := findAttrValue(token, "srcset"); ok && m.inPicture {
+			for _, src := range parseSrcset(srcset) {
+				m.addImage(baseURL, src, "")
+			}
+		}
+	case atom.Video:
+		video := Video{}
+		video.Title, _ = findAttrValue(token, "title")
+		if src, ok := findAttrValue(token, "src"); ok {
+			video.URL, _ = resolveMediaURL(baseURL, src)
+		}
+		if poster, ok := findAttrValue(token, "poster"); ok {
+			video.ThumbnailURL, _ = resolveMediaURL(baseURL, poster)
+		}
+		m.videos = append(m.videos, video)
+		m.openVideo = len(m.videos) - 1
+	}
+}
+
+// endTag closes the <picture> or <video> being read
+func (m *mediaCollector) endTag(a atom.Atom) {
+	switch a {
+	case atom.Picture:
+		m.inPicture = false
+	case atom.Video:
+		m.openVideo = -1
+	}
+}
+
+// addImage adds the image at src if it wasn't seen before
+func (m *mediaCollector) addImage(baseURL, src, alt string) {
+	imageURL, ok := resolveMediaURL(baseURL, src)
+	if !ok {
+		return
+	}
+	if _, ok := m.seen[imageURL]; ok {
+		return
+	}
+	m.seen[imageURL] = struct{}{}
+	m.images = append(m.images, Image{URL: imageURL, Alt: strings.Join(strings.Fields(alt), " ")})
+}
+
+// resolveMediaURL resolves src against baseURL. Unlike links, the query is
+// kept. Returns false for empty sources and for sources which aren't http
+// or https. Eg: data URIs
+func resolveMediaURL(baseURL, src string) (string, bool) {
+	src = strings.TrimSpace(src)
+	if src == "" {
+		return "", false
+	}
+	resolved, err := resolveURL(baseURL, src)
+	if err != nil {
+		return "", false
+	}
+	u, err := url.Parse(resolved)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+		return "", false
+	}
+	return resolved, true
+}
+
+// parseSrcset returns the URLs of a srcset attribute.
+// Eg: "small.jpg 480w, large.jpg 1080w" => [small.jpg large.jpg]
+func parseSrcset(srcset string) []string {
+	var urls []string
+	for _, candidate := range strings.Split(srcset, ",") {
+		if fields := strings.Fields(candidate); len(fields) > 0 {
+			urls = append(urls, fields[0])
+		}
+	}
+	return urls
+}
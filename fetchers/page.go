@@ -0,0 +1,159 @@
+package fetchers
+
+import (
+	"errors"
+	"io"
+	"mime"
+	"net/http"
+	"time"
+)
+
+// PageResult is the result of fetching a single URL
+type PageResult struct {
+	URL           string        // URL is the requested URL
+	FinalURL      string        // FinalURL is the URL of the page after following the redirects
+	StatusCode    int           // StatusCode is the status of the final response. 0 if no response was received
+	ContentType   string        // ContentType is the media type of the page, without parameters
+	ContentLength int64         // ContentLength is the size of the body in bytes. -1 if unknown
+	ResponseTime  time.Duration // ResponseTime is the time taken to fetch the page, including the body
+	Redirects     []Redirect    // Redirects is the chain of redirects followed to reach FinalURL, including the redirect the client stopped at if any
+	Attempts      int           // Attempts is the number of requests sent for the URL, including the retries. 0 if no request was sent
+	Err           error         // Err is set if the page couldn't be fetched
+	// Document is the information extracted from the page. LastModified
+	// falls back to the Last-Modified header if the page doesn't declare it.
+	Document
+}
+
+// Document is the information extracted from the body of an HTML page
+type Document struct {
+	Title        string      // Title is the <title> of the page
+	Links        []Link      // Links are the links found on the page
+	Images       []Image     // Images are the images found on the page
+	Videos       []Video     // Videos are the videos found on the page
+	Alternates   []Alternate // Alternates are the language versions of the page declared with hreflang
+	LastModified time.Time   // LastModified is the time the page was last modified. Zero if unknown
+}
+
+// Redirect is a single hop of a redirect chain
+type Redirect struct {
+	URL        string // URL is the URL which was redirected
+	StatusCode int    // StatusCode is the redirect status. Eg: 301
+	Location   string // Location is the value of the Location header
+}
+
+// ErrRedirectLoop is set in PageResult.Err when the redirects of a URL lead
+// back to a URL of the chain
+var ErrRedirectLoop = errors.New("redirect loop")
+
+// ErrTooManyRedirects is set in PageResult.Err when the client stopped
+// following the redirects because the chain exceeded the max number of
+// redirects. See WithMaxRedirects
+var ErrTooManyRedirects = errors.New("too many redirects")
+
+// IsHTML checks if the page can contain links. Pages without a content
+// type are assumed to be HTML.
+func (p *PageResult) IsHTML() bool {
+	switch p.ContentType {
+	case "", "text/html", "application/xhtml+xml":
+		return true
+	}
+	return false
+}
+
+// IsSuccess checks if the page was fetched and returned a 2xx status.
+// Responses without a status are assumed to be successful.
+func (p *PageResult) IsSuccess() bool {
+	return p.Err == nil && (p.StatusCode == 0 || (p.StatusCode >= 200 && p.StatusCode < 300))
+}
+
+// newPageResult builds a PageResult from the response headers and the
+// redirects which led to the response. maxRedirects is the number of
+// redirects the client follows.
+func newPageResult(url string, resp *http.Response, maxRedirects int) *PageResult {
+	page := &PageResult{
+		URL:           url,
+		FinalURL:      url,
+		StatusCode:    resp.StatusCode,
+		ContentLength: resp.ContentLength,
+	}
+	if contentType := resp.Header.Get("Content-Type"); contentType != "" {
+		if mediaType, _, err := mime.ParseMediaType(contentType); err == nil {
+			page.ContentType = mediaType
+		} else {
+			page.ContentType = contentType
+		}
+	}
+	if lastModified, err := http.ParseTime(resp.Header.Get("Last-Modified")); err == nil {
+		page.LastModified = lastModified
+	}
+	if resp.Request != nil && resp.Request.URL != nil {
+		page.FinalURL = resp.Request.URL.String()
+		page.Redirects = redirectChain(resp.Request)
+	}
+	// The client returns the redirect it didn't follow. It ends the chain.
+	if location := resp.Header.Get("Location"); isRedirect(resp.StatusCode) && location != "" {
+		page.Redirects = append(page.Redirects, Redirect{URL: page.FinalURL, StatusCode: resp.StatusCode, Location: location})
+		page.Err = redirectError(page.Redirects, maxRedirects)
+	}
+	return page
+}
+
+// isRedirect checks if the status is one of the redirects followed by
+// http.Client
+func isRedirect(status int) bool {
+	switch status {
+	case http.StatusMovedPermanently, http.StatusFound, http.StatusSeeOther,
+		http.StatusTemporaryRedirect, http.StatusPermanentRedirect:
+		return true
+	}
+	return false
+}
+
+// redirectError returns the error of a chain whose last redirect was not
+// followed: ErrRedirectLoop if it points back to a URL of the chain,
+// ErrTooManyRedirects if the chain has more than maxRedirects redirects.
+// Returns nil if the client doesn't follow the redirects (maxRedirects is 0)
+// or stopped for another reason.
+func redirectError(chain []Redirect, maxRedirects int) error {
+	last := chain[len(chain)-1]
+	if target, err := resolveURL(last.URL, last.Location); err == nil {
+		for _, hop := range chain {
+			if hop.URL == target {
+				return ErrRedirectLoop
+			}
+		}
+	}
+	if maxRedirects > 0 && len(chain) > maxRedirects {
+		return ErrTooManyRedirects
+	}
+	return nil
+}
+
+// redirectChain returns the redirects which led to req, oldest first
+func redirectChain(req *http.Request) []Redirect {
+	var chain []Redirect
+	// req.Response is the redirect response which caused req to be sent
+	for ; req != nil && req.Response != nil; req = req.Response.Request {
+		redirect := Redirect{
+			StatusCode: req.Response.StatusCode,
+			Location:   req.Response.Header.Get("Location"),
+		}
+		if req.Response.Request != nil && req.Response.Request.URL != nil {
+			redirect.URL = req.Response.Request.URL.String()
+		}
+		chain = append([]Redirect{redirect}, chain...)
+	}
+	return chain
+}
+
+// countingReader counts the number of bytes read from r
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
@@ -0,0 +1,151 @@
+package fetchers
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// HostLimit is the politeness policy for the hosts matching Pattern.
+type HostLimit struct {
+	// Pattern is matched against the host name using path.Match.
+	// Eg: "example.com", "*.example.com" or "*" for all hosts
+	Pattern string
+	// RequestsPerSecond is the max number of requests per second sent to a
+	// host. Zero means no limit.
+	RequestsPerSecond float64
+	// MinDelay is the minimum delay between two requests to a host.
+	MinDelay time.Duration
+	// Jitter is the max random delay added on top of the delay between two
+	// requests to a host.
+	Jitter time.Duration
+}
+
+// interval returns the delay between two requests, without the jitter
+func (l HostLimit) interval() time.Duration {
+	interval := l.MinDelay
+	if l.RequestsPerSecond > 0 {
+		if d := time.Duration(float64(time.Second) / l.RequestsPerSecond); d > interval {
+			interval = d
+		}
+	}
+	return interval
+}
+
+// ParseHostLimit parses a HostLimit from a string of the form
+// "pattern,rps=2,delay=500ms,jitter=100ms". All fields except the pattern
+// are optional.
+func ParseHostLimit(s string) (HostLimit, error) {
+	fields := strings.Split(s, ",")
+	limit := HostLimit{Pattern: strings.TrimSpace(fields[0])}
+	if limit.Pattern == "" {
+		return HostLimit{}, fmt.Errorf("invalid host limit %q: missing host pattern", s)
+	}
+	if _, err := path.Match(limit.Pattern, ""); err != nil {
+		return HostLimit{}, fmt.Errorf("invalid host limit %q: %w", s, err)
+	}
+	for _, field := range fields[1:] {
+		kv := strings.SplitN(strings.TrimSpace(field), "=", 2)
+		if len(kv) != 2 {
+			return HostLimit{}, fmt.Errorf("invalid host limit %q: expected key=value, got %q", s, field)
+		}
+		var err error
+		switch kv[0] {
+		case "rps":
+			limit.RequestsPerSecond, err = strconv.ParseFloat(kv[1], 64)
+		case "delay":
+			limit.MinDelay, err = time.ParseDuration(kv[1])
+		case "jitter":
+			limit.Jitter, err = time.ParseDuration(kv[1])
+		default:
+			err = fmt.Errorf("unknown key %q", kv[0])
+		}
+		if err != nil {
+			return HostLimit{}, fmt.Errorf("invalid host limit %q: %w", s, err)
+		}
+	}
+	return limit, nil
+}
+
+// RateLimiter delays requests so that the requests sent to a host respect
+// the HostLimit matching that host. It is go routine safe.
+type RateLimiter struct {
+	limits      []HostLimit
+	next        map[string]time.Time     // next stores the time a host can be requested again
+	crawlDelays map[string]time.Duration // crawlDelays stores the Crawl-delay of robots.txt per host
+	sync.Mutex
+}
+
+// NewRateLimiter returns a RateLimiter enforcing the given limits. When more
+// than one limit matches a host, the first one is used.
+func NewRateLimiter(limits ...HostLimit) *RateLimiter {
+	return &RateLimiter{
+		limits:      limits,
+		next:        make(map[string]time.Time),
+		crawlDelays: make(map[string]time.Duration),
+	}
+}
+
+// SetCrawlDelay sets the minimum delay between two requests to the host,
+// as requested by the Crawl-delay of robots.txt. It applies on top of the
+// HostLimit matching the host.
+func (r *RateLimiter) SetCrawlDelay(host string, delay time.Duration) {
+	r.Lock()
+	r.crawlDelays[strings.ToLower(host)] = delay
+	r.Unlock()
+}
+
+// limitFor returns the first limit matching the host
+func (r *RateLimiter) limitFor(host string) (HostLimit, bool) {
+	for _, limit := range r.limits {
+		if ok, _ := path.Match(limit.Pattern, host); ok {
+			return limit, true
+		}
+	}
+	return HostLimit{}, false
+}
+
+// Wait blocks until a request can be sent to the host. Returns ctx.Err() if
+// ctx is done before that.
+func (r *RateLimiter) Wait(ctx context.Context, host string) error {
+	host = strings.ToLower(host)
+	r.Lock()
+	limit, ok := r.limitFor(host)
+	crawlDelay := r.crawlDelays[host]
+	if !ok && crawlDelay == 0 {
+		r.Unlock()
+		return nil
+	}
+	// Reserve the next slot for this request
+	now := time.Now()
+	at := now
+	if next := r.next[host]; next.After(now) {
+		at = next
+	}
+	delay := limit.interval()
+	if crawlDelay > delay {
+		delay = crawlDelay
+	}
+	if limit.Jitter > 0 {
+		delay += time.Duration(rand.Int63n(int64(limit.Jitter)))
+	}
+	r.next[host] = at.Add(delay)
+	r.Unlock()
+
+	if !at.After(now) {
+		return nil
+	}
+	timer := time.NewTimer(at.Sub(now))
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
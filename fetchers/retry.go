@@ -0,0 +1,159 @@
+package fetchers
+
+import (
+	"context"
+	"errors"
+	"io"
+	"math/rand"
+	"net"
+	"net/http"
+	"strconv"
+	"syscall"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// RetryPolicy controls how the requests which failed with a transient error
+// are retried: timeouts, connection resets, 429 and 5xx responses (except
+// 501 Not Implemented).
+type RetryPolicy struct {
+	// MaxAttempts is the max number of requests sent for a URL, including
+	// the first one. 1 disables the retries.
+	MaxAttempts int
+	// BaseDelay is the delay before the first retry. It is doubled at every
+	// retry.
+	BaseDelay time.Duration
+	// MaxDelay is the max delay between two attempts. The request isn't
+	// retried if the server asks to wait longer with Retry-After.
+	MaxDelay time.Duration
+	// Jitter is the max random delay added to the delay between two
+	// attempts.
+	Jitter time.Duration
+}
+
+// DefaultRetryPolicy is the retry policy used when WithRetryPolicy is not
+// provided
+var DefaultRetryPolicy = RetryPolicy{
+	MaxAttempts: 3,
+	BaseDelay:   500 * time.Millisecond,
+	MaxDelay:    30 * time.Second,
+	Jitter:      250 * time.Millisecond,
+}
+
+// backoff returns the delay before the given retry. retry is 1 for the
+// first retry.
+func (p RetryPolicy) backoff(retry int) time.Duration {
+	delay := p.BaseDelay
+	for i := 1; i < retry && delay < p.MaxDelay; i++ {
+		delay *= 2
+	}
+	if p.MaxDelay > 0 && delay > p.MaxDelay {
+		delay = p.MaxDelay
+	}
+	if p.Jitter > 0 {
+		delay += time.Duration(rand.Int63n(int64(p.Jitter)))
+	}
+	return delay
+}
+
+// isRetryableError checks if the request failed with a transient network
+// error
+func isRetryableError(err error) bool {
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return true
+	}
+	return errors.Is(err, syscall.ECONNRESET) || errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, io.EOF)
+}
+
+// isRetryableStatus checks if the status of a response is transient
+func isRetryableStatus(status int) bool {
+	return status == http.StatusTooManyRequests ||
+		(status >= 500 && status != http.StatusNotImplemented)
+}
+
+// parseRetryAfter returns the delay requested by the Retry-After header.
+// The header is either a number of seconds or an HTTP date. Returns false
+// if the header is missing or invalid.
+func parseRetryAfter(value string, now time.Time) (time.Duration, bool) {
+	if value == "" {
+		return 0, false
+	}
+	if seconds, err := strconv.Atoi(value); err == nil {
+		if seconds < 0 {
+			return 0, false
+		}
+		return time.Duration(seconds) * time.Second, true
+	}
+	at, err := http.ParseTime(value)
+	if err != nil {
+		return 0, false
+	}
+	if delay := at.Sub(now); delay > 0 {
+		return delay, true
+	}
+	return 0, true
+}
+
+// doWithRetry sends the request and retries it as set by the retry policy
+// of the fetcher. The rate limiter is waited for before every retry.
+// Returns the last response or error along with the number of requests
+// sent.
+func (f SimpleFetcher) doWithRetry(ctx context.Context, method, url string) (*http.Response, int, error) {
+	policy := f.retry
+	if policy.MaxAttempts < 1 {
+		policy.MaxAttempts = 1
+	}
+	for attempt := 1; ; attempt++ {
+		resp, err := f.do(ctx, method, url)
+		if attempt == policy.MaxAttempts || ctx.Err() != nil {
+			return resp, attempt, err
+		}
+
+		var delay time.Duration
+		switch {
+		case err != nil && isRetryableError(err):
+			delay = policy.backoff(attempt)
+		case err == nil && isRetryableStatus(resp.StatusCode):
+			delay = policy.backoff(attempt)
+			if retryAfter, ok := parseRetryAfter(resp.Header.Get("Retry-After"), time.Now()); ok {
+				if policy.MaxDelay > 0 && retryAfter > policy.MaxDelay {
+					return resp, attempt, err
+				}
+				if retryAfter > delay {
+					delay = retryAfter
+				}
+			}
+			// The response is discarded since the request is retried
+			io.Copy(io.Discard, resp.Body)
+			resp.Body.Close()
+		default:
+			return resp, attempt, err
+		}
+
+		log.WithFields(log.Fields{
+			"url":     url,
+			"attempt": attempt,
+			"delay":   delay,
+		}).Info("Request failed. Retrying")
+		if err := sleep(ctx, delay); err != nil {
+			return nil, attempt, err
+		}
+		if err := f.wait(ctx, url); err != nil {
+			return nil, attempt, err
+		}
+	}
+}
+
+// sleep waits for the delay. Returns ctx.Err() if ctx is done before that.
+func sleep(ctx context.Context, delay time.Duration) error {
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,290 @@
+package fetchers
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// ErrDisallowedByRobots is returned by Fetch when the robots.txt of the host
+// doesn't allow the user agent to fetch the URL.
+var ErrDisallowedByRobots = errors.New("URL disallowed by robots.txt")
+
+// robotsRule is a single Allow or Disallow line of robots.txt
+type robotsRule struct {
+	allow   bool
+	pattern string         // pattern is the raw path pattern. Used to find the most specific rule
+	re      *regexp.Regexp // re matches the paths the rule applies to
+}
+
+// robotsRules stores the rules of robots.txt which apply to a user agent
+type robotsRules struct {
+	rules      []robotsRule
+	crawlDelay time.Duration
+}
+
+// allowAll is used when a host has no usable robots.txt
+var allowAll = &robotsRules{}
+
+// disallowAll is used when robots.txt of a host is unreachable
+var disallowAll = &robotsRules{rules: []robotsRule{{pattern: "/", re: compileRobotsPattern("/")}}}
+
+// allowed checks if the path (including the query) can be fetched. The
+// longest matching rule wins. Allow wins when an Allow and a Disallow rule
+// of the same length match.
+func (r *robotsRules) allowed(path string) bool {
+	allowed := true
+	matched := -1
+	for _, rule := range r.rules {
+		if !rule.re.MatchString(path) {
+			continue
+		}
+		if len(rule.pattern) > matched || (len(rule.pattern) == matched && rule.allow) {
+			allowed = rule.allow
+			matched = len(rule.pattern)
+		}
+	}
+	return allowed
+}
+
+// compileRobotsPattern converts a robots.txt path pattern to a regexp.
+// "*" matches any sequence of characters and a trailing "$" anchors the
+// pattern to the end of the path.
+func compileRobotsPattern(pattern string) *regexp.Regexp {
+	anchored := strings.HasSuffix(pattern, "$")
+	pattern = strings.TrimSuffix(pattern, "$")
+	parts := strings.Split(pattern, "*")
+	for i, part := range parts {
+		parts[i] = regexp.QuoteMeta(part)
+	}
+	expr := "^" + strings.Join(parts, ".*")
+	if anchored {
+		expr += "$"
+	}
+	return regexp.MustCompile(expr)
+}
+
+// robotsGroup is a set of rules which apply to the listed user agents
+type robotsGroup struct {
+	agents []string
+	robotsRules
+}
+
+// robotsProductToken returns the product token of the user agent: the name
+// the robots.txt groups are matched against, in lower case.
+// Eg: "webcrawler/1.0" => "webcrawler". Browser-like user agents carry the
+// name of the crawler in their comment:
+// "Mozilla/5.0 (compatible; webcrawler/1.0; +https://example.com/bot)" => "webcrawler"
+func robotsProductToken(userAgent string) string {
+	if start := strings.Index(userAgent, "("); start >= 0 {
+		comment := userAgent[start+1:]
+		if end := strings.Index(comment, ")"); end >= 0 {
+			comment = comment[:end]
+		}
+		parts := strings.Split(comment, ";")
+		if len(parts) > 1 && strings.EqualFold(strings.TrimSpace(parts[0]), "compatible") {
+			userAgent = parts[1]
+		}
+	}
+	userAgent = strings.TrimSpace(userAgent)
+	if i := strings.IndexAny(userAgent, "/ ("); i >= 0 {
+		userAgent = userAgent[:i]
+	}
+	return strings.ToLower(userAgent)
+}
+
+// parseRobots parses robots.txt and returns the rules which apply to the
+// user agent. The groups whose user agent is the product token of userAgent
+// are used (see robotsProductToken). Falls back to the "*" group.
+func parseRobots(body io.Reader, userAgent string) *robotsRules {
+	var groups []*robotsGroup
+	var current *robotsGroup
+	// lastWasAgent is true if the previous line was a user-agent line.
+	// Consecutive user-agent lines share the same group.
+	lastWasAgent := false
+
+	scanner := bufio.NewScanner(body)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if i := strings.Index(line, "#"); i >= 0 {
+			line = line[:i]
+		}
+		kv := strings.SplitN(line, ":", 2)
+		if len(kv) != 2 {
+			continue
+		}
+		key := strings.ToLower(strings.TrimSpace(kv[0]))
+		value := strings.TrimSpace(kv[1])
+
+		if key == "user-agent" {
+			if !lastWasAgent || current == nil {
+				current = &robotsGroup{}
+				groups = append(groups, current)
+			}
+			current.agents = append(current.agents, strings.ToLower(value))
+			lastWasAgent = true
+			continue
+		}
+		lastWasAgent = false
+		if current == nil {
+			// rules before the first user-agent line are ignored
+			continue
+		}
+		switch key {
+		case "allow", "disallow":
+			// An empty Disallow means everything is allowed
+			if value == "" {
+				continue
+			}
+			current.rules = append(current.rules, robotsRule{
+				allow:   key == "allow",
+				pattern: value,
+				re:      compileRobotsPattern(value),
+			})
+		case "crawl-delay":
+			if seconds, err := strconv.ParseFloat(value, 64); err == nil && seconds > 0 {
+				current.crawlDelay = time.Duration(seconds * float64(time.Second))
+			}
+		}
+	}
+
+	token := robotsProductToken(userAgent)
+	rules := &robotsRules{}
+	matched := -1
+	for _, group := range groups {
+		// specificity is 1 if the group names the product token and 0 if it
+		// is a "*" group
+		specificity := -1
+		for _, agent := range group.agents {
+			if agent == "*" && specificity < 0 {
+				specificity = 0
+			} else if agent != "" && agent == token {
+				specificity = 1
+			}
+		}
+		if specificity < 0 || specificity < matched {
+			continue
+		}
+		if specificity > matched {
+			// A more specific group was found. Discard the rules collected so far
+			rules = &robotsRules{}
+			matched = specificity
+		}
+		// Groups for the same user agent are merged
+		rules.rules = append(rules.rules, group.rules...)
+		if group.crawlDelay > rules.crawlDelay {
+			rules.crawlDelay = group.crawlDelay
+		}
+	}
+	return rules
+}
+
+// robotsEntry is a cached robots.txt. ready is closed once rules is set. rules
+// is nil if the download was aborted.
+type robotsEntry struct {
+	ready chan struct{}
+	rules *robotsRules
+}
+
+// robotsCache stores the parsed robots.txt of every host. It is go routine
+// safe. robots.txt is downloaded only once per host, unless the download is
+// aborted.
+type robotsCache struct {
+	entries map[string]*robotsEntry
+	sync.Mutex
+}
+
+func newRobotsCache() *robotsCache {
+	return &robotsCache{entries: make(map[string]*robotsEntry)}
+}
+
+// get returns the rules for the host of u. The first caller for a host
+// downloads robots.txt using download, the other callers wait for it.
+// download returns false if it was aborted (eg. its context is done): its
+// rules are then only returned to the caller and the next caller downloads
+// robots.txt again.
+func (c *robotsCache) get(u *url.URL, download func(robotsURL string) (*robotsRules, bool)) *robotsRules {
+	key := u.Scheme + "://" + u.Host
+	for {
+		c.Lock()
+		entry, ok := c.entries[key]
+		if !ok {
+			entry = &robotsEntry{ready: make(chan struct{})}
+			c.entries[key] = entry
+		}
+		c.Unlock()
+
+		if !ok {
+			rules, complete := download(key + "/robots.txt")
+			if complete {
+				entry.rules = rules
+			} else {
+				c.Lock()
+				delete(c.entries, key)
+				c.Unlock()
+			}
+			close(entry.ready)
+			return rules
+		}
+		<-entry.ready
+		if entry.rules != nil {
+			return entry.rules
+		}
+	}
+}
+
+// robotsAllowed checks if robots.txt of the host allows fetching rawURL.
+// The Crawl-delay of the host is passed on to the rate limiter.
+func (f SimpleFetcher) robotsAllowed(ctx context.Context, rawURL string) bool {
+	if f.robots == nil {
+		return true
+	}
+	u, err := url.Parse(rawURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+		return true
+	}
+	rules := f.robots.get(u, func(robotsURL string) (*robotsRules, bool) {
+		rules := f.fetchRobots(ctx, robotsURL)
+		return rules, ctx.Err() == nil
+	})
+	if rules.crawlDelay > 0 && f.limiter != nil {
+		f.limiter.SetCrawlDelay(u.Hostname(), rules.crawlDelay)
+	}
+	return rules.allowed(u.RequestURI())
+}
+
+// fetchRobots downloads and parses robots.txt. Everything is allowed if
+// robots.txt is missing or returns a 4xx status. As required by RFC 9309,
+// everything is disallowed if robots.txt is unreachable or still returns a
+// 5xx status once the request is retried as set by the retry policy.
+func (f SimpleFetcher) fetchRobots(ctx context.Context, robotsURL string) *robotsRules {
+	contextLogger := log.WithField("url", robotsURL)
+	if err := f.wait(ctx, robotsURL); err != nil {
+		return disallowAll
+	}
+	resp, attempts, err := f.doWithRetry(ctx, http.MethodGet, robotsURL)
+	if err != nil {
+		contextLogger.WithField("attempts", attempts).Warnf("Failed to fetch robots.txt: %s. Disallowing the host", err)
+		return disallowAll
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 500 {
+		contextLogger.WithField("attempts", attempts).Warnf("robots.txt returned status %d. Disallowing the host", resp.StatusCode)
+		return disallowAll
+	}
+	if resp.StatusCode != 0 && resp.StatusCode != http.StatusOK {
+		contextLogger.Infof("robots.txt returned status %d", resp.StatusCode)
+		return allowAll
+	}
+	return parseRobots(resp.Body, f.userAgent)
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+
+	"github.com/jarifibrahim/webcrawler/fetchers"
+)
+
+// stringList is a flag.Value which collects the values of a repeated flag
+type stringList []string
+
+func (s *stringList) String() string {
+	return strings.Join(*s, " ")
+}
+
+func (s *stringList) Set(value string) error {
+	*s = append(*s, value)
+	return nil
+}
+
+// readHeaders reads the headers of fileName, if set, and of the -header
+// flags. A -header flag replaces the header of the same name of the file.
+func readHeaders(fileName string, values []string) (http.Header, error) {
+	header := make(http.Header)
+	if fileName != "" {
+		file, err := os.Open(fileName)
+		if err != nil {
+			return nil, err
+		}
+		defer file.Close()
+		if header, err = fetchers.ReadHeaders(file); err != nil {
+			return nil, fmt.Errorf("%s: %w", fileName, err)
+		}
+	}
+	replaced := make(map[string]bool)
+	for _, value := range values {
+		name, value, err := fetchers.ParseHeader(value)
+		if err != nil {
+			return nil, err
+		}
+		if !replaced[name] {
+			header.Del(name)
+			replaced[name] = true
+		}
+		header.Add(name, value)
+	}
+	return header, nil
+}
+
+// parseForm parses the fields of a form of the form "name=value"
+func parseForm(fields []string) (url.Values, error) {
+	form := make(url.Values)
+	for _, field := range fields {
+		kv := strings.SplitN(field, "=", 2)
+		if len(kv) != 2 || kv[0] == "" {
+			return nil, fmt.Errorf("invalid form field %q: expected name=value", field)
+		}
+		form.Add(kv[0], kv[1])
+	}
+	return form, nil
+}
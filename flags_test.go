@@ -0,0 +1,35 @@
+package main
+
+import (
+	"net/url"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseForm(t *testing.T) {
+	testData := []struct {
+		name          string
+		fields        []string
+		expected      url.Values
+		expectedError string
+	}{
+		{"fields", []string{"username=alice", "password=a=b", "username=bob"},
+			url.Values{"username": {"alice", "bob"}, "password": {"a=b"}}, ""},
+		{"empty value", []string{"remember="}, url.Values{"remember": {""}}, ""},
+		{"missing value", []string{"foo"}, nil, `invalid form field "foo": expected name=value`},
+		{"missing name", []string{"=bar"}, nil, `invalid form field "=bar": expected name=value`},
+	}
+	for _, tt := range testData {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			form, err := parseForm(tt.fields)
+			if tt.expectedError != "" {
+				assert.EqualError(t, err, tt.expectedError)
+			} else {
+				assert.Nil(t, err)
+			}
+			assert.Equal(t, tt.expected, form)
+		})
+	}
+}
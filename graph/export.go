@@ -0,0 +1,171 @@
+package graph
+
+import (
+	"bufio"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// clusters groups the nodes by Cluster, in the order the clusters are first
+// seen
+func (g *Graph) clusters(segments int) ([]string, map[string][]Node) {
+	var names []string
+	members := make(map[string][]Node)
+	for _, node := range g.nodes {
+		name := Cluster(node.URL, segments)
+		if _, ok := members[name]; !ok {
+			names = append(names, name)
+		}
+		members[name] = append(members[name], node)
+	}
+	return names, members
+}
+
+// dotQuote returns s as a quoted DOT string
+func dotQuote(s string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(s) + `"`
+}
+
+// writeDOTNode writes the statement of a node. The nodes which returned an
+// error status are red.
+func writeDOTNode(w io.Writer, indent string, node Node) {
+	attrs := fmt.Sprintf("depth=%d, status=%d", node.Depth, node.Status)
+	if node.Status >= 400 {
+		attrs += ", color=red"
+	}
+	fmt.Fprintf(w, "%s%s [%s];\n", indent, dotQuote(node.URL), attrs)
+}
+
+// WriteDOT writes the graph in the Graphviz DOT format. The nodes are
+// grouped in clusters by the first clusterSegments segments of their path.
+// No clusters are used if clusterSegments is negative. The links to other
+// hosts are dashed. Sample graph
+//
+//	digraph links {
+//		node [shape=box];
+//		subgraph "cluster_0" {
+//			label="foo.com/";
+//			"https://foo.com/" [depth=0, status=200];
+//		}
+//		"https://foo.com/" -> "https://bar.com/" [internal=false, style=dashed];
+//	}
+func (g *Graph) WriteDOT(w io.Writer, clusterSegments int) error {
+	bw := bufio.NewWriter(w)
+	fmt.Fprintln(bw, "digraph links {")
+	fmt.Fprintln(bw, "\tnode [shape=box];")
+	if clusterSegments < 0 {
+		for _, node := range g.nodes {
+			writeDOTNode(bw, "\t", node)
+		}
+	} else {
+		names, members := g.clusters(clusterSegments)
+		for i, name := range names {
+			fmt.Fprintf(bw, "\tsubgraph %s {\n", dotQuote("cluster_"+strconv.Itoa(i)))
+			fmt.Fprintf(bw, "\t\tlabel=%s;\n", dotQuote(name))
+			for _, node := range members[name] {
+				writeDOTNode(bw, "\t\t", node)
+			}
+			fmt.Fprintln(bw, "\t}")
+		}
+	}
+	for _, edge := range g.edges {
+		fmt.Fprintf(bw, "\t%s -> %s", dotQuote(edge.Source), dotQuote(edge.Target))
+		if !edge.Internal {
+			fmt.Fprint(bw, " [internal=false, style=dashed]")
+		}
+		fmt.Fprintln(bw, ";")
+	}
+	fmt.Fprintln(bw, "}")
+	return bw.Flush()
+}
+
+// GraphMLNamespace is the XML namespace of GraphML
+const GraphMLNamespace = "http://graphml.graphdrawing.org/xmlns"
+
+type graphML struct {
+	XMLName xml.Name     `xml:"graphml"`
+	XMLNS   string       `xml:"xmlns,attr"`
+	Keys    []graphMLKey `xml:"key"`
+	Graph   struct {
+		ID          string        `xml:"id,attr"`
+		EdgeDefault string        `xml:"edgedefault,attr"`
+		Nodes       []graphMLNode `xml:"node"`
+		Edges       []graphMLEdge `xml:"edge"`
+	} `xml:"graph"`
+}
+
+type graphMLKey struct {
+	ID   string `xml:"id,attr"`
+	For  string `xml:"for,attr"`
+	Name string `xml:"attr.name,attr"`
+	Type string `xml:"attr.type,attr"`
+}
+
+type graphMLData struct {
+	Key   string `xml:"key,attr"`
+	Value string `xml:",chardata"`
+}
+
+type graphMLNode struct {
+	ID   string        `xml:"id,attr"`
+	Data []graphMLData `xml:"data"`
+}
+
+type graphMLEdge struct {
+	Source string        `xml:"source,attr"`
+	Target string        `xml:"target,attr"`
+	Data   []graphMLData `xml:"data"`
+}
+
+// graphMLKeys are the attributes of the nodes and the edges
+var graphMLKeys = []graphMLKey{
+	{ID: "url", For: "node", Name: "url", Type: "string"},
+	{ID: "depth", For: "node", Name: "depth", Type: "int"},
+	{ID: "status", For: "node", Name: "status", Type: "int"},
+	{ID: "cluster", For: "node", Name: "cluster", Type: "string"},
+	{ID: "anchor", For: "edge", Name: "anchor", Type: "string"},
+	{ID: "internal", For: "edge", Name: "internal", Type: "boolean"},
+}
+
+// WriteGraphML writes the graph in the GraphML format. The cluster of every
+// node (see Cluster) is stored in the "cluster" attribute so that the nodes
+// can be partitioned by it. Eg: in Gephi
+func (g *Graph) WriteGraphML(w io.Writer, clusterSegments int) error {
+	doc := graphML{XMLNS: GraphMLNamespace, Keys: graphMLKeys}
+	doc.Graph.ID = "links"
+	doc.Graph.EdgeDefault = "directed"
+	for i, node := range g.nodes {
+		data := []graphMLData{
+			{Key: "url", Value: node.URL},
+			{Key: "depth", Value: strconv.Itoa(node.Depth)},
+			{Key: "status", Value: strconv.Itoa(node.Status)},
+		}
+		if clusterSegments >= 0 {
+			data = append(data, graphMLData{Key: "cluster", Value: Cluster(node.URL, clusterSegments)})
+		}
+		doc.Graph.Nodes = append(doc.Graph.Nodes, graphMLNode{ID: "n" + strconv.Itoa(i), Data: data})
+	}
+	for _, edge := range g.edges {
+		doc.Graph.Edges = append(doc.Graph.Edges, graphMLEdge{
+			Source: "n" + strconv.Itoa(g.index[edge.Source]),
+			Target: "n" + strconv.Itoa(g.index[edge.Target]),
+			Data: []graphMLData{
+				{Key: "anchor", Value: edge.Text},
+				{Key: "internal", Value: strconv.FormatBool(edge.Internal)},
+			},
+		})
+	}
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	if err := enc.Encode(doc); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, "\n")
+	return err
+}
@@ -0,0 +1,180 @@
+// Package graph stores the links between the crawled URLs and exports them
+// in the Graphviz DOT and GraphML formats. Unlike the tree package, every
+// link is kept, including the links to the URLs which were already seen.
+package graph
+
+import (
+	"net/url"
+	"strings"
+
+	"github.com/jarifibrahim/webcrawler/tree"
+)
+
+// Node is a URL of the graph
+type Node struct {
+	URL    string
+	Depth  int // Depth is the click depth of the URL
+	Status int // Status is the status of the URL. 0 if the URL was not fetched
+}
+
+// Edge is a link from the page at Source to Target
+type Edge struct {
+	Source   string
+	Target   string
+	Text     string // Text is the anchor text of the link
+	Internal bool   // Internal is set if Target is on the same host as Source
+}
+
+// Graph is a directed graph of the links between URLs. The nodes and the
+// edges are kept in the order they were added.
+// It is not go routine safe.
+type Graph struct {
+	nodes []Node
+	index map[string]int // index stores the position of every URL in nodes
+	edges []Edge
+	out   map[string][]int // out stores the position in edges of the links from every URL
+	in    map[string][]int // in stores the position in edges of the links to every URL
+}
+
+// New returns an empty graph
+func New() *Graph {
+	return &Graph{
+		index: make(map[string]int),
+		out:   make(map[string][]int),
+		in:    make(map[string][]int),
+	}
+}
+
+// AddNode adds a node to the graph. The node is replaced if its URL is
+// already present.
+func (g *Graph) AddNode(node Node) {
+	if i, ok := g.index[node.URL]; ok {
+		g.nodes[i] = node
+		return
+	}
+	g.index[node.URL] = len(g.nodes)
+	g.nodes = append(g.nodes, node)
+}
+
+// AddEdge adds an edge to the graph. The URLs of the edge are added as
+// nodes if they are not present.
+func (g *Graph) AddEdge(edge Edge) {
+	for _, u := range []string{edge.Source, edge.Target} {
+		if _, ok := g.index[u]; !ok {
+			g.AddNode(Node{URL: u})
+		}
+	}
+	g.out[edge.Source] = append(g.out[edge.Source], len(g.edges))
+	g.in[edge.Target] = append(g.in[edge.Target], len(g.edges))
+	g.edges = append(g.edges, edge)
+}
+
+// Node returns the node of the URL. Returns false if the URL is not in the
+// graph.
+func (g *Graph) Node(url string) (Node, bool) {
+	i, ok := g.index[url]
+	if !ok {
+		return Node{}, false
+	}
+	return g.nodes[i], true
+}
+
+// Nodes returns the nodes of the graph
+func (g *Graph) Nodes() []Node {
+	return g.nodes
+}
+
+// Edges returns the edges of the graph
+func (g *Graph) Edges() []Edge {
+	return g.edges
+}
+
+// OutEdges returns the links from the URL, in the order they were added
+func (g *Graph) OutEdges(url string) []Edge {
+	return g.edgesAt(g.out[url])
+}
+
+// InEdges returns the links to the URL, in the order they were added
+func (g *Graph) InEdges(url string) []Edge {
+	return g.edgesAt(g.in[url])
+}
+
+// edgesAt returns the edges at the given positions
+func (g *Graph) edgesAt(positions []int) []Edge {
+	edges := make([]Edge, 0, len(positions))
+	for _, i := range positions {
+		edges = append(edges, g.edges[i])
+	}
+	return edges
+}
+
+// Subgraph returns the graph of the nodes for which keep returns true and
+// of the edges between them
+func (g *Graph) Subgraph(keep func(Node) bool) *Graph {
+	sub := New()
+	for _, node := range g.nodes {
+		if keep(node) {
+			sub.AddNode(node)
+		}
+	}
+	for _, edge := range g.edges {
+		_, source := sub.index[edge.Source]
+		_, target := sub.index[edge.Target]
+		if source && target {
+			sub.AddEdge(edge)
+		}
+	}
+	return sub
+}
+
+// SpanningTree returns the breadth first spanning tree of the URLs reachable
+// from root. Every URL is a child of the first URL linking to it at its
+// shortest distance from root, so every URL appears once in the tree.
+func (g *Graph) SpanningTree(root string) *tree.URLNode {
+	rootNode := tree.NewNode(root)
+	visited := map[string]bool{root: true}
+	type item struct {
+		url  string
+		node *tree.URLNode
+	}
+	queue := []item{{url: root, node: rootNode}}
+	for len(queue) > 0 {
+		current := queue[0]
+		queue = queue[1:]
+		for _, edge := range g.OutEdges(current.url) {
+			if visited[edge.Target] {
+				continue
+			}
+			visited[edge.Target] = true
+			queue = append(queue, item{url: edge.Target, node: current.node.AddChild(edge.Target)})
+		}
+	}
+	return rootNode
+}
+
+// Cluster returns the host of rawURL followed by the first segments of its
+// path. The nodes with the same cluster are grouped together by the
+// exporters.
+// Eg: Cluster("https://foo.com/blog/2020/post", 1) => "foo.com/blog"
+func Cluster(rawURL string, segments int) string {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return rawURL
+	}
+	// The last segment of the path is the page, not a directory.
+	// Eg: "/about" is in the cluster of "/"
+	dir := u.Path
+	if i := strings.LastIndex(dir, "/"); i >= 0 {
+		dir = dir[:i]
+	}
+	var prefix []string
+	for _, segment := range strings.Split(dir, "/") {
+		if len(prefix) == segments {
+			break
+		}
+		if segment != "" {
+			prefix = append(prefix, segment)
+		}
+	}
+	return u.Host + "/" + strings.Join(prefix, "/")
+}
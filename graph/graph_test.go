@@ -0,0 +1,249 @@
+package graph
+
+import (
+	"bytes"
+	"encoding/xml"
+	"testing"
+
+	"github.com/jarifibrahim/webcrawler/tree"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCluster(t *testing.T) {
+	tests := []struct {
+		url      string
+		segments int
+		want     string
+	}{
+		{"https://foo.com/", 1, "foo.com/"},
+		{"https://foo.com", 1, "foo.com/"},
+		{"https://foo.com/about", 1, "foo.com/"},
+		{"https://foo.com/blog/", 1, "foo.com/blog"},
+		{"https://foo.com/blog/post", 1, "foo.com/blog"},
+		{"https://foo.com/blog/2020/post", 1, "foo.com/blog"},
+		{"https://foo.com/blog/2020/post", 2, "foo.com/blog/2020"},
+		{"https://foo.com/blog/2020/post", 0, "foo.com/"},
+		{"https://foo.com//blog//post?a=b", 1, "foo.com/blog"},
+	}
+	for _, tt := range tests {
+		assert.Equal(t, tt.want, Cluster(tt.url, tt.segments), tt.url)
+	}
+}
+
+func TestAddEdge(t *testing.T) {
+	g := New()
+	g.AddNode(Node{URL: "a", Depth: 0, Status: 200})
+	g.AddEdge(Edge{Source: "a", Target: "b", Internal: true})
+	g.AddEdge(Edge{Source: "b", Target: "a", Internal: true})
+	// The node added by AddEdge is replaced
+	g.AddNode(Node{URL: "b", Depth: 1, Status: 404})
+
+	assert.Equal(t, []Node{{URL: "a", Status: 200}, {URL: "b", Depth: 1, Status: 404}}, g.Nodes())
+	assert.Len(t, g.Edges(), 2)
+	assert.Equal(t, []Edge{{Source: "b", Target: "a", Internal: true}}, g.InEdges("a"))
+	assert.Equal(t, []Edge{{Source: "a", Target: "b", Internal: true}}, g.OutEdges("a"))
+	assert.Empty(t, g.InEdges("c"))
+	node, ok := g.Node("b")
+	assert.True(t, ok)
+	assert.Equal(t, 404, node.Status)
+	_, ok = g.Node("c")
+	assert.False(t, ok)
+}
+
+func TestSpanningTree(t *testing.T) {
+	// a -> b -> d
+	// a -> c -> d
+	// d -> a
+	g := New()
+	for _, edge := range [][2]string{{"a", "b"}, {"a", "c"}, {"c", "d"}, {"b", "d"}, {"d", "a"}} {
+		g.AddEdge(Edge{Source: edge[0], Target: edge[1]})
+	}
+	expected := tree.NewNode("a")
+	expected.AddChild("b").AddChild("d")
+	expected.AddChild("c")
+	assert.Equal(t, expected, g.SpanningTree("a"))
+
+	expected = tree.NewNode("c")
+	expected.AddChild("d").AddChild("a").AddChild("b")
+	assert.Equal(t, expected, g.SpanningTree("c"))
+	// A root without links is a tree of a single node
+	assert.Equal(t, tree.NewNode("e"), g.SpanningTree("e"))
+}
+
+// testGraph returns a graph of a site with a broken page and a link to
+// another site
+func testGraph() *Graph {
+	g := New()
+	g.AddNode(Node{URL: "https://foo.com/", Depth: 0, Status: 200})
+	g.AddNode(Node{URL: "https://foo.com/blog/post", Depth: 1, Status: 200})
+	g.AddNode(Node{URL: "https://foo.com/missing", Depth: 1, Status: 404})
+	g.AddNode(Node{URL: "https://bar.com/", Depth: 1})
+	g.AddEdge(Edge{Source: "https://foo.com/", Target: "https://foo.com/blog/post", Text: "Post", Internal: true})
+	g.AddEdge(Edge{Source: "https://foo.com/", Target: "https://foo.com/missing", Text: `"Missing"`, Internal: true})
+	g.AddEdge(Edge{Source: "https://foo.com/blog/post", Target: "https://bar.com/", Text: "Bar"})
+	return g
+}
+
+func TestWriteDOT(t *testing.T) {
+	t.Run("with clusters", func(t *testing.T) {
+		var buf bytes.Buffer
+		assert.Nil(t, testGraph().WriteDOT(&buf, 1))
+		assert.Equal(t, `digraph links {
+	node [shape=box];
+	subgraph "cluster_0" {
+		label="foo.com/";
+		"https://foo.com/" [depth=0, status=200];
+		"https://foo.com/missing" [depth=1, status=404, color=red];
+	}
+	subgraph "cluster_1" {
+		label="foo.com/blog";
+		"https://foo.com/blog/post" [depth=1, status=200];
+	}
+	subgraph "cluster_2" {
+		label="bar.com/";
+		"https://bar.com/" [depth=1, status=0];
+	}
+	"https://foo.com/" -> "https://foo.com/blog/post";
+	"https://foo.com/" -> "https://foo.com/missing";
+	"https://foo.com/blog/post" -> "https://bar.com/" [internal=false, style=dashed];
+}
+`, buf.String())
+	})
+
+	t.Run("without clusters", func(t *testing.T) {
+		var buf bytes.Buffer
+		assert.Nil(t, testGraph().WriteDOT(&buf, -1))
+		assert.NotContains(t, buf.String(), "subgraph")
+		assert.Contains(t, buf.String(), "\t\"https://foo.com/missing\" [depth=1, status=404, color=red];\n")
+	})
+
+	t.Run("quoting", func(t *testing.T) {
+		g := New()
+		g.AddNode(Node{URL: `https://foo.com/"quoted"\path`})
+		var buf bytes.Buffer
+		assert.Nil(t, g.WriteDOT(&buf, -1))
+		assert.Contains(t, buf.String(), `"https://foo.com/\"quoted\"\\path"`)
+	})
+}
+
+func TestWriteGraphML(t *testing.T) {
+	var buf bytes.Buffer
+	assert.Nil(t, testGraph().WriteGraphML(&buf, 1))
+
+	var doc graphML
+	assert.Nil(t, xml.Unmarshal(buf.Bytes(), &doc))
+	assert.Equal(t, GraphMLNamespace, doc.XMLName.Space)
+	assert.Equal(t, "directed", doc.Graph.EdgeDefault)
+	if assert.Len(t, doc.Graph.Nodes, 4) {
+		assert.Equal(t, graphMLNode{ID: "n2", Data: []graphMLData{
+			{Key: "url", Value: "https://foo.com/missing"},
+			{Key: "depth", Value: "1"},
+			{Key: "status", Value: "404"},
+			{Key: "cluster", Value: "foo.com/"},
+		}}, doc.Graph.Nodes[2])
+	}
+	assert.Equal(t, []graphMLEdge{
+		{Source: "n0", Target: "n1", Data: []graphMLData{{Key: "anchor", Value: "Post"}, {Key: "internal", Value: "true"}}},
+		{Source: "n0", Target: "n2", Data: []graphMLData{{Key: "anchor", Value: `"Missing"`}, {Key: "internal", Value: "true"}}},
+		{Source: "n1", Target: "n3", Data: []graphMLData{{Key: "anchor", Value: "Bar"}, {Key: "internal", Value: "false"}}},
+	}, doc.Graph.Edges)
+
+	// Every data key is declared
+	keys := make(map[string]bool)
+	for _, key := range doc.Keys {
+		keys[key.ID] = true
+	}
+	for _, node := range doc.Graph.Nodes {
+		for _, data := range node.Data {
+			assert.True(t, keys[data.Key], data.Key)
+		}
+	}
+}
+
+func TestSubgraph(t *testing.T) {
+	g := testGraph()
+	sub := g.Subgraph(func(node Node) bool {
+		return Cluster(node.URL, 0) == "foo.com/"
+	})
+	assert.Len(t, sub.Nodes(), 3)
+	assert.Len(t, sub.Edges(), 2)
+	_, ok := sub.Node("https://bar.com/")
+	assert.False(t, ok)
+	// The graph isn't modified
+	assert.Len(t, g.Nodes(), 4)
+}
+
+// rankGraph returns a graph of the given links
+func rankGraph(links ...[2]string) *Graph {
+	g := New()
+	for _, link := range links {
+		g.AddEdge(Edge{Source: link[0], Target: link[1]})
+	}
+	return g
+}
+
+func TestPageRank(t *testing.T) {
+	t.Run("cycle", func(t *testing.T) {
+		ranks := rankGraph([2]string{"a", "b"}, [2]string{"b", "c"}, [2]string{"c", "a"}).PageRank(RankOptions{})
+		for _, url := range []string{"a", "b", "c"} {
+			assert.InDelta(t, 1.0/3, ranks[url], 1e-6)
+		}
+	})
+	t.Run("star", func(t *testing.T) {
+		// Every page links to the home page, which links back to them
+		g := rankGraph(
+			[2]string{"home", "a"}, [2]string{"home", "b"}, [2]string{"home", "c"},
+			[2]string{"a", "home"}, [2]string{"b", "home"}, [2]string{"c", "home"},
+			// Self links are ignored
+			[2]string{"a", "a"},
+		)
+		ranks := g.PageRank(RankOptions{})
+		sum := 0.0
+		for _, rank := range ranks {
+			sum += rank
+		}
+		assert.InDelta(t, 1, sum, 1e-6)
+		// The rank of home is (1-d)/n + d * (sum of the other ranks)
+		assert.InDelta(t, 0.0375+0.85*(1-ranks["home"]), ranks["home"], 1e-5)
+		assert.Greater(t, ranks["home"], ranks["a"])
+		assert.InDelta(t, ranks["a"], ranks["b"], 1e-9)
+	})
+	t.Run("dangling page", func(t *testing.T) {
+		// b has no links. Its rank is spread over all the pages
+		ranks := rankGraph([2]string{"a", "b"}).PageRank(RankOptions{Damping: 0.5})
+		assert.InDelta(t, 1, ranks["a"]+ranks["b"], 1e-6)
+		assert.InDelta(t, 0.4, ranks["a"], 1e-6)
+		assert.InDelta(t, 0.6, ranks["b"], 1e-6)
+	})
+	t.Run("iterations", func(t *testing.T) {
+		g := rankGraph([2]string{"a", "b"}, [2]string{"b", "c"}, [2]string{"c", "b"})
+		once := g.PageRank(RankOptions{Iterations: 1})
+		converged := g.PageRank(RankOptions{Threshold: 1e-12})
+		assert.NotEqual(t, once, converged)
+		// A high threshold stops after the first iteration
+		assert.Equal(t, once, g.PageRank(RankOptions{Threshold: 10}))
+	})
+	t.Run("empty graph", func(t *testing.T) {
+		assert.Empty(t, New().PageRank(RankOptions{}))
+	})
+}
+
+func TestHITS(t *testing.T) {
+	// index links to every post. The posts link to about
+	g := rankGraph(
+		[2]string{"index", "post1"}, [2]string{"index", "post2"}, [2]string{"index", "about"},
+		[2]string{"post1", "about"}, [2]string{"post2", "about"},
+	)
+	hubs, authorities := g.HITS(RankOptions{})
+	assert.Equal(t, 1.0, hubs["index"])
+	assert.Equal(t, 1.0, authorities["about"])
+	assert.Greater(t, hubs["index"], hubs["post1"])
+	assert.Greater(t, authorities["about"], authorities["post1"])
+	assert.InDelta(t, authorities["post1"], authorities["post2"], 1e-9)
+	assert.Equal(t, 0.0, hubs["about"])
+	assert.Equal(t, 0.0, authorities["index"])
+
+	hubs, authorities = New().HITS(RankOptions{})
+	assert.Empty(t, hubs)
+	assert.Empty(t, authorities)
+}
@@ -0,0 +1,166 @@
+package graph
+
+import "math"
+
+const (
+	// DefaultDamping is the PageRank damping factor used when
+	// RankOptions.Damping is not set
+	DefaultDamping = 0.85
+	// DefaultIterations is the max number of iterations used when
+	// RankOptions.Iterations is not set
+	DefaultIterations = 100
+	// DefaultThreshold is the convergence threshold used when
+	// RankOptions.Threshold is not set
+	DefaultThreshold = 1e-6
+)
+
+// RankOptions controls the iterative computation of PageRank and HITS
+type RankOptions struct {
+	Damping    float64 // Damping is the probability of following a link. Only used by PageRank
+	Iterations int     // Iterations is the max number of iterations
+	// Threshold stops the iterations once the scores change by less than
+	// Threshold in total
+	Threshold float64
+}
+
+// withDefaults returns the options with the defaults set for the missing
+// values
+func (o RankOptions) withDefaults() RankOptions {
+	if o.Damping <= 0 || o.Damping >= 1 {
+		o.Damping = DefaultDamping
+	}
+	if o.Iterations <= 0 {
+		o.Iterations = DefaultIterations
+	}
+	if o.Threshold <= 0 {
+		o.Threshold = DefaultThreshold
+	}
+	return o
+}
+
+// links returns the links between the nodes as positions in nodes. The links
+// of a node to itself are skipped.
+func (g *Graph) links() [][2]int {
+	links := make([][2]int, 0, len(g.edges))
+	for _, edge := range g.edges {
+		source, target := g.index[edge.Source], g.index[edge.Target]
+		if source != target {
+			links = append(links, [2]int{source, target})
+		}
+	}
+	return links
+}
+
+// PageRank returns the PageRank of every URL of the graph. The ranks add up
+// to 1. The rank of the URLs without links (eg. not crawled) is spread over
+// all the URLs. A URL linking several times to the same URL passes more of
+// its rank to it.
+func (g *Graph) PageRank(opts RankOptions) map[string]float64 {
+	opts = opts.withDefaults()
+	n := len(g.nodes)
+	ranks := make(map[string]float64, n)
+	if n == 0 {
+		return ranks
+	}
+	links := g.links()
+	outDegree := make([]int, n)
+	for _, link := range links {
+		outDegree[link[0]]++
+	}
+
+	rank := make([]float64, n)
+	for i := range rank {
+		rank[i] = 1 / float64(n)
+	}
+	next := make([]float64, n)
+	for iteration := 0; iteration < opts.Iterations; iteration++ {
+		dangling := 0.0
+		for i, degree := range outDegree {
+			if degree == 0 {
+				dangling += rank[i]
+			}
+		}
+		base := (1-opts.Damping)/float64(n) + opts.Damping*dangling/float64(n)
+		for i := range next {
+			next[i] = base
+		}
+		for _, link := range links {
+			next[link[1]] += opts.Damping * rank[link[0]] / float64(outDegree[link[0]])
+		}
+		delta := 0.0
+		for i := range rank {
+			delta += math.Abs(next[i] - rank[i])
+		}
+		rank, next = next, rank
+		if delta < opts.Threshold {
+			break
+		}
+	}
+
+	for i, node := range g.nodes {
+		ranks[node.URL] = rank[i]
+	}
+	return ranks
+}
+
+// HITS returns the hub and the authority scores of every URL of the graph.
+// A good hub links to good authorities and a good authority is linked from
+// good hubs. The scores are normalized so that the highest score is 1.
+// opts.Damping is not used.
+func (g *Graph) HITS(opts RankOptions) (hubs, authorities map[string]float64) {
+	opts = opts.withDefaults()
+	n := len(g.nodes)
+	links := g.links()
+	hub := make([]float64, n)
+	authority := make([]float64, n)
+	for i := range hub {
+		hub[i] = 1
+	}
+	nextHub := make([]float64, n)
+	for iteration := 0; iteration < opts.Iterations; iteration++ {
+		for i := range authority {
+			authority[i] = 0
+		}
+		for _, link := range links {
+			authority[link[1]] += hub[link[0]]
+		}
+		normalize(authority)
+		for i := range nextHub {
+			nextHub[i] = 0
+		}
+		for _, link := range links {
+			nextHub[link[0]] += authority[link[1]]
+		}
+		normalize(nextHub)
+		delta := 0.0
+		for i := range hub {
+			delta += math.Abs(nextHub[i] - hub[i])
+		}
+		hub, nextHub = nextHub, hub
+		if delta < opts.Threshold {
+			break
+		}
+	}
+
+	hubs = make(map[string]float64, n)
+	authorities = make(map[string]float64, n)
+	for i, node := range g.nodes {
+		hubs[node.URL] = hub[i]
+		authorities[node.URL] = authority[i]
+	}
+	return hubs, authorities
+}
+
+// normalize divides the scores by the highest score
+func normalize(scores []float64) {
+	highest := 0.0
+	for _, score := range scores {
+		highest = math.Max(highest, score)
+	}
+	if highest == 0 {
+		return
+	}
+	for i := range scores {
+		scores[i] /= highest
+	}
+}
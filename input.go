@@ -0,0 +1,87 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/jarifibrahim/webcrawler/fetchers"
+	"github.com/jarifibrahim/webcrawler/sitemap"
+)
+
+// readURLList reads the URLs of a sitemap, a sitemap index or a seed list.
+// name is a file path or an http(s) URL. The sitemaps of a sitemap index are
+// read as well. A seed list has one URL per line. The empty lines and the
+// lines starting with # are skipped. The URLs are downloaded with the
+// fetcher, so they are sent with the client settings, the headers and the
+// credentials of the crawl.
+func readURLList(ctx context.Context, fetcher *fetchers.SimpleFetcher, name string) ([]string, error) {
+	data, err := readInput(ctx, fetcher, name)
+	if err != nil {
+		return nil, err
+	}
+	trimmed := bytes.TrimSpace(data)
+	if !bytes.HasPrefix(trimmed, []byte("<")) && !bytes.HasPrefix(data, []byte{0x1f, 0x8b}) {
+		return readSeedList(bytes.NewReader(data))
+	}
+
+	locs, index, err := sitemap.ReadLocs(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", name, err)
+	}
+	if !index {
+		return locs, nil
+	}
+	var urls []string
+	for _, loc := range locs {
+		data, err := readInput(ctx, fetcher, loc)
+		if err != nil {
+			return nil, err
+		}
+		sitemapURLs, index, err := sitemap.ReadLocs(bytes.NewReader(data))
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", loc, err)
+		}
+		if index {
+			return nil, fmt.Errorf("%s: sitemap indexes can't be nested", loc)
+		}
+		urls = append(urls, sitemapURLs...)
+	}
+	return urls, nil
+}
+
+// readInput returns the content of a file or of an http(s) URL. The URL is
+// downloaded with the fetcher and the request is bound to ctx.
+func readInput(ctx context.Context, fetcher *fetchers.SimpleFetcher, name string) ([]byte, error) {
+	if !strings.HasPrefix(name, "http://") && !strings.HasPrefix(name, "https://") {
+		return os.ReadFile(name)
+	}
+	resp, err := fetcher.Get(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to fetch %s: %s", name, resp.Status)
+	}
+	return io.ReadAll(resp.Body)
+}
+
+// readSeedList returns the URLs of a seed list
+func readSeedList(r io.Reader) ([]string, error) {
+	var urls []string
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		urls = append(urls, line)
+	}
+	return urls, scanner.Err()
+}
@@ -1,10 +1,25 @@
+// webcrawler crawls a website and generates its sitemap.
+//
+// Usage:
+//
+//	webcrawler [flags]        crawls the site and generates the sitemap and the tree
+//	webcrawler check [flags]  reports the broken links. Exits with status 1 if
+//	                          broken links are found
 package main
 
 import (
+	"context"
+	"errors"
 	"flag"
+	"fmt"
+	"io"
 	"os"
+	"os/signal"
 
 	"github.com/jarifibrahim/webcrawler/crawler"
+	"github.com/jarifibrahim/webcrawler/fetchers"
+	"github.com/jarifibrahim/webcrawler/graph"
+	"github.com/jarifibrahim/webcrawler/sitemap"
 	log "github.com/sirupsen/logrus"
 )
 
@@ -12,24 +27,406 @@ func main() {
 	log.SetFormatter(&log.TextFormatter{
 		FullTimestamp: true,
 	})
+	args := os.Args[1:]
+	checkMode := len(args) > 0 && args[0] == "check"
+	if checkMode {
+		args = args[1:]
+	}
+
 	baseURL := flag.String("baseurl", "http://jarifibrahim.github.io", "Base URL to crawl")
 	maxDepth := flag.Int("max-depth", 3, "Max Depth to crawl")
 	sitemapFileName := flag.String("sitemap-file-name", "sitemap.xml", "File to write sitemap")
+	sitemapDir := flag.String("sitemap-dir", "", "Directory to write the sitemap split into files of 50,000 URLs along with sitemap_index.xml. Replaces -sitemap-file-name")
+	sitemapBaseURL := flag.String("sitemap-base-url", "", "Public URL of -sitemap-dir used in sitemap_index.xml. Defaults to -baseurl")
+	sitemapGzip := flag.Bool("sitemap-gzip", false, "Gzip the files written to -sitemap-dir")
 	showTree := flag.Bool("show-tree", true, "Show links between pages")
 	treeFileName := flag.String("tree-file-name", "url-tree.txt", "File to write the generated tree")
-	flag.Parse()
+	concurrency := flag.Int("concurrency", 10, "Number of pages fetched in parallel")
+	bfs := flag.Bool("bfs", false, "Crawl level by level. Every page is recorded at its shortest click depth")
+	timeout := flag.Duration("timeout", 0, "Stop crawling after the given duration (0 means no timeout)")
+	userAgent := flag.String("user-agent", "", "User agent sent with the requests and used to match the robots.txt rules. Defaults to the User-Agent of -header or -header-file, then to "+fetchers.DefaultUserAgent)
+	var headers stringList
+	flag.Var(&headers, "header", "Header sent with every request, eg. \"X-Crawler: true\". Can be repeated. Replaces the header of the same name in -header-file")
+	headerFile := flag.String("header-file", "", "File of the headers sent with every request, one \"Name: value\" per line. Lines starting with # are ignored")
+	var auths stringList
+	flag.Var(&auths, "auth", "Credentials sent to the hosts matching a pattern, eg. \"staging.example.com,user=alice,password=secret\" or \"*.example.com,token=secret\". Can be repeated, the first match is used")
+	cookiesFile := flag.String("cookies-file", "", "Netscape cookies.txt file the cookies are loaded from before the crawl and saved to after the crawl")
+	loginURL := flag.String("login-url", "", "URL the -login-field form is posted to before the crawl. The session cookies are used by the crawl")
+	var loginFields stringList
+	flag.Var(&loginFields, "login-field", "Field of the login form, eg. \"username=alice\". Can be repeated")
+	acceptLanguage := flag.String("accept-language", "", "Accept-Language header sent with every request, eg. \"fr-FR, fr;q=0.9\"")
+	ignoreRobots := flag.Bool("ignore-robots", false, "Ignore robots.txt. Useful for internal sites")
+	retryAttempts := flag.Int("retry-attempts", fetchers.DefaultRetryPolicy.MaxAttempts, "Max number of requests sent for a URL which fails with a timeout, a connection reset, a 429 or a 5xx status. 1 disables the retries")
+	retryDelay := flag.Duration("retry-delay", fetchers.DefaultRetryPolicy.BaseDelay, "Delay before the first retry. It is doubled at every retry")
+	retryMaxDelay := flag.Duration("retry-max-delay", fetchers.DefaultRetryPolicy.MaxDelay, "Max delay between two attempts. The URL isn't retried if Retry-After asks to wait longer")
+	retryJitter := flag.Duration("retry-jitter", fetchers.DefaultRetryPolicy.Jitter, "Max random delay added to the delay between two attempts")
+	requestTimeout := flag.Duration("request-timeout", fetchers.DefaultClientConfig.Timeout, "Max time taken by a request, including the redirects and the body (0 means no limit)")
+	connectTimeout := flag.Duration("connect-timeout", fetchers.DefaultClientConfig.ConnectTimeout, "Max time taken to connect to a host, including the TLS handshake (0 means no limit)")
+	readTimeout := flag.Duration("read-timeout", 0, "Max time waited for the response headers once a request is sent (0 means no limit)")
+	maxIdleConnsPerHost := flag.Int("max-idle-conns-per-host", 0, "Number of idle connections kept per host. 0 uses the Go default")
+	disableHTTP2 := flag.Bool("disable-http2", false, "Only use HTTP/1.1")
+	maxRedirects := flag.Int("max-redirects", fetchers.DefaultMaxRedirects, "Max number of redirects followed for a URL. The redirects are not followed past a loop either. 0 disables the redirects")
+	caFile := flag.String("ca-file", "", "PEM bundle of CA certificates trusted on top of the system ones")
+	clientCert := flag.String("client-cert", "", "PEM file of the client certificate sent to the servers requesting one. Requires -client-key")
+	clientKey := flag.String("client-key", "", "PEM file of the key of -client-cert")
+	insecureSkipVerify := flag.Bool("insecure-skip-verify", false, "Don't verify the server certificates. Only meant for staging environments")
+	proxy := flag.String("proxy", "", "URL of the HTTP, HTTPS or SOCKS5 proxy, eg. socks5://proxy:1080. Defaults to the HTTP_PROXY and HTTPS_PROXY environment variables")
+	var hostLimits stringList
+	flag.Var(&hostLimits, "host-limit", "Rate limit for the hosts matching a pattern, eg. \"*.example.com,rps=2,delay=500ms,jitter=100ms\". Can be repeated")
+	priority := flag.String("sitemap-priority", "none", "Derive the sitemap <priority> from the click \"depth\", the \"inbound\" link count or the \"pagerank\", or \"none\"")
+	sitemapImages := flag.Bool("sitemap-images", false, "Add the images of the pages to the sitemap")
+	sitemapVideos := flag.Bool("sitemap-videos", false, "Add the videos of the pages to the sitemap")
+	sitemapHreflang := flag.Bool("sitemap-hreflang", false, "Add the hreflang alternates of the pages to the sitemap")
+	hreflangReportFileName := flag.String("hreflang-report-file-name", "", "File to write the report of the hreflang alternates without a return link")
+	var changeFreqs stringList
+	flag.Var(&changeFreqs, "changefreq", "Sitemap <changefreq> of the URLs matching a regexp, eg. \"/blog/=daily\". Can be repeated, the first match is used")
+	outputJSONL := flag.String("output-jsonl", "", "File to stream a JSON record of every crawled URL to, one per line")
+	pagesCSV := flag.String("pages-csv", "", "File to write the CSV table of the pages (url, depth, status, title, inbound, outbound)")
+	edgesCSV := flag.String("edges-csv", "", "File to write the CSV table of the links (source, target, anchor, rel, internal)")
+	dotFileName := flag.String("dot-file-name", "", "File to write the link graph in the Graphviz DOT format")
+	graphMLFileName := flag.String("graphml-file-name", "", "File to write the link graph in the GraphML format")
+	clusterDepth := flag.Int("cluster-depth", 1, "Number of path segments the nodes of the link graph are clustered by. 0 clusters by host, -1 disables clustering")
+	maxRedirectHops := flag.Int("max-redirect-hops", 1, "Number of redirects a URL may go through before its chain is reported as too long. 0 disables the check")
+	redirectsReportFileName := flag.String("redirects-report-file-name", "", "File to write the report of the URLs which redirect, with their redirect chain, loops and chains longer than -max-redirect-hops")
+	orphansInput := flag.String("orphans-input", "", "Sitemap, sitemap index or seed list (one URL per line) of the pages expected on the site. File path or URL. The pages not reached through links are reported as orphans")
+	orphansReportFileName := flag.String("orphans-report-file-name", "", "File to write the orphan page report to. Defaults to stdout")
+	scoresJSON := flag.String("scores-json", "", "File to write the PageRank and the hub and authority scores of the pages to as JSON")
+	pageRankDamping := flag.Float64("pagerank-damping", graph.DefaultDamping, "PageRank damping factor: the probability of following a link")
+	pageRankIterations := flag.Int("pagerank-iterations", graph.DefaultIterations, "Max number of iterations of PageRank and HITS")
+	pageRankThreshold := flag.Float64("pagerank-threshold", graph.DefaultThreshold, "PageRank and HITS stop once the scores change by less than the threshold")
+	checkExternal := flag.Bool("check-external", false, "check mode: Check the links to other domains with HEAD requests")
+	reportFileName := flag.String("report-file-name", "", "check mode: File to write the broken link report. Defaults to stdout")
+	flag.CommandLine.Parse(args)
+
+	var hostAuths []fetchers.HostAuth
+	for _, value := range auths {
+		auth, err := fetchers.ParseHostAuth(value)
+		if err != nil {
+			log.Fatal(err)
+		}
+		hostAuths = append(hostAuths, auth)
+	}
+	loginForm, err := parseForm(loginFields)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	var limits []fetchers.HostLimit
+	for _, value := range hostLimits {
+		limit, err := fetchers.ParseHostLimit(value)
+		if err != nil {
+			log.Fatal(err)
+		}
+		limits = append(limits, limit)
+	}
+
+	header, err := readHeaders(*headerFile, headers)
+	if err != nil {
+		log.Fatal(err)
+	}
+	// The user agent is set separately since robots.txt is matched against it
+	if *userAgent == "" {
+		*userAgent = header.Get("User-Agent")
+	}
+	if *userAgent == "" {
+		*userAgent = fetchers.DefaultUserAgent
+	}
+	header.Del("User-Agent")
+	if *acceptLanguage != "" {
+		header.Set("Accept-Language", *acceptLanguage)
+	}
+
+	priorityMode, err := sitemap.ParsePriorityMode(*priority)
+	if err != nil {
+		log.Fatal(err)
+	}
+	siteMapConfig := sitemap.Config{
+		Priority:   priorityMode,
+		Images:     *sitemapImages,
+		Videos:     *sitemapVideos,
+		Alternates: *sitemapHreflang,
+	}
+	for _, value := range changeFreqs {
+		rule, err := sitemap.ParseChangeFreqRule(value)
+		if err != nil {
+			log.Fatal(err)
+		}
+		siteMapConfig.ChangeFreq = append(siteMapConfig.ChangeFreq, rule)
+	}
+
+	// Stop crawling on SIGINT. The pages crawled so far are still written.
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	if *timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, *timeout)
+		defer cancel()
+	}
 
-	siteMapFile, err := os.Create(*sitemapFileName)
+	// Cookies are only stored when they are needed by the crawl
+	var jar *fetchers.CookieJar
+	if *cookiesFile != "" || *loginURL != "" {
+		jar = fetchers.NewCookieJar()
+	}
+	if *cookiesFile != "" {
+		if err := loadCookies(jar, *cookiesFile); err != nil {
+			log.Fatal(err)
+		}
+	}
+	clientConfig := fetchers.ClientConfig{
+		Timeout:             *requestTimeout,
+		ConnectTimeout:      *connectTimeout,
+		ReadTimeout:         *readTimeout,
+		MaxIdleConnsPerHost: *maxIdleConnsPerHost,
+		DisableHTTP2:        *disableHTTP2,
+		MaxRedirects:        *maxRedirects,
+		CAFile:              *caFile,
+		CertFile:            *clientCert,
+		KeyFile:             *clientKey,
+		InsecureSkipVerify:  *insecureSkipVerify,
+		Proxy:               *proxy,
+	}
+	if jar != nil {
+		clientConfig.Jar = jar
+	}
+	client, err := fetchers.NewHTTPClient(clientConfig)
 	if err != nil {
 		log.Fatal(err)
 	}
-	var treeFile *os.File
+	fetcherOpts := []fetchers.FetcherOption{
+		fetchers.WithClient(client),
+		fetchers.WithMaxRedirects(*maxRedirects),
+		fetchers.WithRateLimiter(fetchers.NewRateLimiter(limits...)),
+		fetchers.WithUserAgent(*userAgent),
+		fetchers.WithHeader(header),
+		fetchers.WithAuth(hostAuths...),
+		fetchers.WithRetryPolicy(fetchers.RetryPolicy{
+			MaxAttempts: *retryAttempts,
+			BaseDelay:   *retryDelay,
+			MaxDelay:    *retryMaxDelay,
+			Jitter:      *retryJitter,
+		}),
+	}
+	if *ignoreRobots {
+		fetcherOpts = append(fetcherOpts, fetchers.WithIgnoreRobots())
+	}
+
+	fetcher := fetchers.NewSimpleFetcher(*baseURL, fetcherOpts...)
+	if *loginURL != "" {
+		if err := fetcher.Login(ctx, *loginURL, loginForm); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	opts := []crawler.Option{
+		crawler.WithBaseURL(*baseURL),
+		crawler.WithMaxDepth(*maxDepth),
+		crawler.WithConcurrency(*concurrency),
+		crawler.WithFetcher(fetcher),
+		crawler.WithMaxRedirectHops(*maxRedirectHops),
+	}
+	if *bfs {
+		opts = append(opts, crawler.WithBreadthFirst())
+	}
+	if *outputJSONL != "" {
+		// The records are written as the URLs are crawled. Nothing is
+		// buffered so the file is usable even if the crawl crashes.
+		jsonlFile, err := os.Create(*outputJSONL)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer jsonlFile.Close()
+		opts = append(opts, crawler.WithJSONLWriter(jsonlFile))
+	}
+	if checkMode {
+		broken := check(ctx, append(opts, crawler.WithLinkCheck(*checkExternal)), *reportFileName)
+		if *cookiesFile != "" {
+			saveCookies(jar, *cookiesFile)
+		}
+		if broken {
+			os.Exit(1)
+		}
+		return
+	}
+
+	opts = append(opts, crawler.WithSiteMapConfig(siteMapConfig))
+	// The expected URLs are read before the sitemap is written since
+	// -orphans-input may be the previous sitemap
+	if *orphansInput != "" {
+		expected, err := readURLList(ctx, fetcher, *orphansInput)
+		if err != nil {
+			log.Fatal(err)
+		}
+		opts = append(opts, crawler.WithOrphanCheck(expected))
+	}
+	if *sitemapDir != "" {
+		if *sitemapBaseURL == "" {
+			*sitemapBaseURL = *baseURL
+		}
+		opts = append(opts, crawler.WithSiteMapFiles(sitemap.FileOptions{
+			Dir:     *sitemapDir,
+			BaseURL: *sitemapBaseURL,
+			Gzip:    *sitemapGzip,
+		}))
+	} else {
+		siteMapFile, err := os.Create(*sitemapFileName)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer siteMapFile.Close()
+		opts = append(opts, crawler.WithSiteMapWriter(siteMapFile))
+	}
+
+	if *pagesCSV != "" {
+		pagesFile, err := os.Create(*pagesCSV)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer pagesFile.Close()
+		opts = append(opts, crawler.WithPagesCSVWriter(pagesFile))
+	}
+	if *edgesCSV != "" {
+		edgesFile, err := os.Create(*edgesCSV)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer edgesFile.Close()
+		opts = append(opts, crawler.WithEdgesCSVWriter(edgesFile))
+	}
+
+	opts = append(opts, crawler.WithRankOptions(graph.RankOptions{
+		Damping:    *pageRankDamping,
+		Iterations: *pageRankIterations,
+		Threshold:  *pageRankThreshold,
+	}))
+	if *scoresJSON != "" {
+		scoresFile, err := os.Create(*scoresJSON)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer scoresFile.Close()
+		opts = append(opts, crawler.WithScoresJSONWriter(scoresFile))
+	}
+
+	opts = append(opts, crawler.WithClusterDepth(*clusterDepth))
+	if *dotFileName != "" {
+		dotFile, err := os.Create(*dotFileName)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer dotFile.Close()
+		opts = append(opts, crawler.WithDOTWriter(dotFile))
+	}
+	if *graphMLFileName != "" {
+		graphMLFile, err := os.Create(*graphMLFileName)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer graphMLFile.Close()
+		opts = append(opts, crawler.WithGraphMLWriter(graphMLFile))
+	}
+
 	if *showTree {
-		treeFile, err = os.Create(*treeFileName)
+		treeFile, err := os.Create(*treeFileName)
 		if err != nil {
 			log.Fatal(err)
 		}
+		defer treeFile.Close()
+		opts = append(opts, crawler.WithTreeWriter(treeFile))
 	}
 
-	crawler.StartCrawling(*baseURL, *maxDepth, *showTree, treeFile, siteMapFile)
+	result, err := crawler.New(opts...).Run(ctx)
+	switch {
+	case errors.Is(err, context.Canceled), errors.Is(err, context.DeadlineExceeded):
+		log.Warn("Crawl was stopped. The generated files are partial")
+	case err != nil:
+		log.Fatal(err)
+	}
+	if *cookiesFile != "" {
+		saveCookies(jar, *cookiesFile)
+	}
+	if *hreflangReportFileName != "" {
+		writeReport(*hreflangReportFileName, func(w io.Writer) error {
+			return crawler.WriteHreflangIssues(w, result.HreflangIssues)
+		})
+	}
+	if *redirectsReportFileName != "" {
+		writeReport(*redirectsReportFileName, func(w io.Writer) error {
+			return crawler.WriteRedirects(w, result.Redirects)
+		})
+	}
+	if *orphansInput != "" {
+		writeReport(*orphansReportFileName, func(w io.Writer) error {
+			return crawler.WriteOrphans(w, result.Orphans)
+		})
+	}
+}
+
+// check crawls the site and writes the broken link report to
+// reportFileName (stdout if empty). Returns true if broken links are found.
+func check(ctx context.Context, opts []crawler.Option, reportFileName string) bool {
+	result, err := crawler.New(opts...).Run(ctx)
+	switch {
+	case errors.Is(err, context.Canceled), errors.Is(err, context.DeadlineExceeded):
+		log.Warn("Crawl was stopped. The report is partial")
+	case err != nil:
+		log.Fatal(err)
+	}
+
+	writeReport(reportFileName, func(w io.Writer) error {
+		return crawler.WriteBrokenLinks(w, result.BrokenLinks)
+	})
+	return len(result.BrokenLinks) > 0
+}
+
+// loadCookies loads the cookies of fileName into the jar. A missing file
+// is created when the cookies are saved.
+func loadCookies(jar *fetchers.CookieJar, fileName string) error {
+	file, err := os.Open(fileName)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	if err := jar.Load(file); err != nil {
+		return fmt.Errorf("%s: %w", fileName, err)
+	}
+	return nil
+}
+
+// saveCookies saves the cookies of the jar to fileName. The file is only
+// readable by the user since it may hold session cookies.
+func saveCookies(jar *fetchers.CookieJar, fileName string) {
+	file, err := os.OpenFile(fileName, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := jar.Save(file); err != nil {
+		log.Fatal(err)
+	}
+	if err := file.Close(); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// writeReport writes a report to fileName, or to stdout if fileName is empty
+func writeReport(fileName string, write func(io.Writer) error) {
+	report := os.Stdout
+	if fileName != "" {
+		var err error
+		report, err = os.Create(fileName)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+	if err := write(report); err != nil {
+		log.Fatal(err)
+	}
+	if fileName != "" {
+		if err := report.Close(); err != nil {
+			log.Fatal(err)
+		}
+	}
 }
@@ -0,0 +1,226 @@
+package sitemap
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const (
+	// MaxURLs is the max number of URLs in a single sitemap file
+	MaxURLs = 50000
+	// MaxSize is the max size of a single uncompressed sitemap file in bytes
+	MaxSize = 50 * 1024 * 1024
+	// IndexFileName is the name of the sitemap index written by WriteFiles
+	IndexFileName = "sitemap_index.xml"
+)
+
+// FileOptions controls how the sitemap is split into files
+type FileOptions struct {
+	Dir string // Dir is the directory the files are written to. It is created if missing
+	// BaseURL is the public URL of Dir. The sitemap index references the
+	// files under it. Eg: https://foo.com/sitemaps/
+	BaseURL string
+	Gzip    bool // Gzip compresses the sitemap files. The index isn't compressed
+	MaxURLs int  // MaxURLs is the max number of URLs per file. Defaults to MaxURLs
+	MaxSize int  // MaxSize is the max uncompressed size of a file in bytes. Defaults to MaxSize
+}
+
+// WriteFiles writes the sitemap of urls to opts.Dir. The sitemap is split
+// into files named sitemap-1.xml, sitemap-2.xml... which stay within the
+// protocol limits, and a sitemap index referencing them is written to
+// IndexFileName. Returns the paths of the written files, the index last.
+func WriteFiles(urls []URL, opts FileOptions) ([]string, error) {
+	if opts.MaxURLs <= 0 || opts.MaxURLs > MaxURLs {
+		opts.MaxURLs = MaxURLs
+	}
+	if opts.MaxSize <= 0 || opts.MaxSize > MaxSize {
+		opts.MaxSize = MaxSize
+	}
+	if err := os.MkdirAll(opts.Dir, 0755); err != nil {
+		return nil, err
+	}
+
+	var paths []string
+	var sitemaps []Sitemap
+	var current *partFile
+	closeCurrent := func() error {
+		err := current.close()
+		paths = append(paths, current.path)
+		sitemaps = append(sitemaps, Sitemap{Loc: publicURL(opts.BaseURL, current.name), LastMod: current.lastMod})
+		current = nil
+		return err
+	}
+
+	for _, u := range urls {
+		entry, err := marshalURL(u)
+		if err != nil {
+			return paths, err
+		}
+		if current != nil && (current.urls >= opts.MaxURLs || current.size+len(entry) > opts.MaxSize) {
+			if err := closeCurrent(); err != nil {
+				return paths, err
+			}
+		}
+		if current == nil {
+			if current, err = createPart(opts, len(sitemaps)+1); err != nil {
+				return paths, err
+			}
+		}
+		if err := current.write(entry, u.LastMod); err != nil {
+			current.close()
+			return paths, err
+		}
+	}
+	// An empty sitemap is still written so that the index isn't empty
+	if current == nil {
+		var err error
+		if current, err = createPart(opts, 1); err != nil {
+			return paths, err
+		}
+	}
+	if err := closeCurrent(); err != nil {
+		return paths, err
+	}
+
+	indexPath := filepath.Join(opts.Dir, IndexFileName)
+	index, err := os.Create(indexPath)
+	if err != nil {
+		return paths, err
+	}
+	if err := WriteIndex(index, sitemaps); err != nil {
+		index.Close()
+		return paths, err
+	}
+	if err := index.Close(); err != nil {
+		return paths, err
+	}
+	return append(paths, indexPath), nil
+}
+
+// publicURL returns the URL the file name is served at
+func publicURL(baseURL, name string) string {
+	if baseURL == "" {
+		return name
+	}
+	return strings.TrimSuffix(baseURL, "/") + "/" + name
+}
+
+// partFile is a sitemap file being written by WriteFiles
+type partFile struct {
+	name    string
+	path    string
+	file    *os.File
+	gz      *gzip.Writer // gz is nil if the file isn't compressed
+	w       *bufio.Writer
+	enc     *Encoder
+	urls    int       // urls is the number of URLs written
+	size    int       // size is the uncompressed size of the file, including the footer
+	lastMod time.Time // lastMod is the most recent LastMod of the URLs written
+}
+
+// createPart creates the nth sitemap file
+func createPart(opts FileOptions, n int) (*partFile, error) {
+	name := fmt.Sprintf("sitemap-%d.xml", n)
+	if opts.Gzip {
+		name += ".gz"
+	}
+	p := &partFile{name: name, path: filepath.Join(opts.Dir, name)}
+	var err error
+	if p.file, err = os.Create(p.path); err != nil {
+		return nil, err
+	}
+	var w io.Writer = p.file
+	if opts.Gzip {
+		p.gz = gzip.NewWriter(p.file)
+		w = p.gz
+	}
+	p.w = bufio.NewWriter(w)
+	p.enc = NewEncoder(p.w)
+	p.size = len(xmlHeader) + len(xmlFooter)
+	return p, nil
+}
+
+// write adds a <url> entry to the file
+func (p *partFile) write(entry []byte, lastMod time.Time) error {
+	if err := p.enc.writeEntry(entry); err != nil {
+		return err
+	}
+	p.urls++
+	p.size += len(entry)
+	if lastMod.After(p.lastMod) {
+		p.lastMod = lastMod
+	}
+	return nil
+}
+
+// close writes the footer and closes the file
+func (p *partFile) close() error {
+	err := p.enc.Close()
+	if err == nil {
+		err = p.w.Flush()
+	}
+	if p.gz != nil {
+		if gzErr := p.gz.Close(); err == nil {
+			err = gzErr
+		}
+	}
+	if closeErr := p.file.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// Sitemap is a single <sitemap> entry of a sitemap index
+type Sitemap struct {
+	Loc     string    // Loc is the URL of the sitemap file
+	LastMod time.Time // LastMod is the time the sitemap file was last modified. Omitted if zero
+}
+
+const (
+	indexHeader = xml.Header + `<sitemapindex xmlns="` + Namespace + `">` + "\n"
+	indexFooter = "</sitemapindex>\n"
+)
+
+// xmlSitemap is the <sitemap> element of a Sitemap
+type xmlSitemap struct {
+	XMLName xml.Name `xml:"sitemap"`
+	Loc     string   `xml:"loc"`
+	LastMod string   `xml:"lastmod,omitempty"`
+}
+
+// WriteIndex writes the sitemap index of sitemaps to w. Sample index
+//
+//	<?xml version="1.0" encoding="UTF-8"?>
+//	<sitemapindex xmlns="http://www.sitemaps.org/schemas/sitemap/0.9">
+//	  <sitemap>
+//	    <loc>http://foo.com/sitemap-1.xml.gz</loc>
+//	    <lastmod>2020-01-02T15:04:05Z</lastmod>
+//	  </sitemap>
+//	</sitemapindex>
+func WriteIndex(w io.Writer, sitemaps []Sitemap) error {
+	if _, err := io.WriteString(w, indexHeader); err != nil {
+		return err
+	}
+	for _, s := range sitemaps {
+		x := xmlSitemap{Loc: EscapeLoc(s.Loc)}
+		if !s.LastMod.IsZero() {
+			x.LastMod = s.LastMod.Format(time.RFC3339)
+		}
+		entry, err := xml.MarshalIndent(x, "  ", "  ")
+		if err != nil {
+			return err
+		}
+		if _, err := w.Write(append(entry, '\n')); err != nil {
+			return err
+		}
+	}
+	_, err := io.WriteString(w, indexFooter)
+	return err
+}
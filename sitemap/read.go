@@ -0,0 +1,71 @@
+package sitemap
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// ErrNotSitemap is returned by ReadLocs when the document is neither a
+// sitemap nor a sitemap index
+var ErrNotSitemap = errors.New("sitemap: not a sitemap or a sitemap index")
+
+// ReadLocs returns the <loc> of every entry of the sitemap or the sitemap
+// index read from r. index is set for a sitemap index, in which case the
+// locs are the URLs of the sitemap files. Gzipped documents are
+// decompressed.
+func ReadLocs(r io.Reader) (locs []string, index bool, err error) {
+	br := bufio.NewReader(r)
+	if magic, _ := br.Peek(2); len(magic) == 2 && magic[0] == 0x1f && magic[1] == 0x8b {
+		gz, err := gzip.NewReader(br)
+		if err != nil {
+			return nil, false, err
+		}
+		defer gz.Close()
+		r = gz
+	} else {
+		r = br
+	}
+
+	dec := xml.NewDecoder(r)
+	root := ""
+	for {
+		token, err := dec.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, false, fmt.Errorf("sitemap: %w", err)
+		}
+		start, ok := token.(xml.StartElement)
+		if !ok {
+			continue
+		}
+		if root == "" {
+			root = start.Name.Local
+			if root != "urlset" && root != "sitemapindex" {
+				return nil, false, ErrNotSitemap
+			}
+			continue
+		}
+		// <image:loc> and the other extensions are skipped
+		if start.Name.Local != "loc" || (start.Name.Space != Namespace && start.Name.Space != "") {
+			continue
+		}
+		var loc string
+		if err := dec.DecodeElement(&loc, &start); err != nil {
+			return nil, false, fmt.Errorf("sitemap: %w", err)
+		}
+		if loc = strings.TrimSpace(loc); loc != "" {
+			locs = append(locs, loc)
+		}
+	}
+	if root == "" {
+		return nil, false, ErrNotSitemap
+	}
+	return locs, root == "sitemapindex", nil
+}
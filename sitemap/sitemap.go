@@ -0,0 +1,344 @@
+// Package sitemap writes sitemaps as defined by the sitemap protocol.
+// See https://www.sitemaps.org/protocol.html
+package sitemap
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"math"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// URL is a single <url> entry of a sitemap
+type URL struct {
+	Loc        string      // Loc is the URL of the page
+	LastMod    time.Time   // LastMod is the time the page was last modified. Omitted if zero
+	ChangeFreq string      // ChangeFreq is how frequently the page changes. Omitted if empty
+	Priority   float64     // Priority is the priority of the page relative to the site, between 0.1 and 1.0. Omitted if zero
+	Alternates []Alternate // Alternates are written as <xhtml:link rel="alternate">
+	Images     []Image     // Images are written as <image:image>. At most MaxImages are written
+	Videos     []Video     // Videos are written as <video:video>
+}
+
+// Alternate is a language version of a page
+type Alternate struct {
+	Hreflang string // Hreflang is the language of the alternate page. Eg: "de" or "x-default"
+	Href     string // Href is the URL of the alternate page
+}
+
+// Image is an image of a page
+type Image struct {
+	Loc string // Loc is the URL of the image
+}
+
+// Video is a video of a page. Videos without a ThumbnailLoc, a Title or a
+// ContentLoc are not written as they are required by the protocol.
+type Video struct {
+	ThumbnailLoc string // ThumbnailLoc is the URL of the thumbnail of the video
+	Title        string
+	Description  string // Description defaults to the Title
+	ContentLoc   string // ContentLoc is the URL of the video file
+}
+
+const (
+	// Namespace is the XML namespace of sitemaps
+	Namespace = "http://www.sitemaps.org/schemas/sitemap/0.9"
+	// ImageNamespace is the XML namespace of the image sitemap extension
+	ImageNamespace = "http://www.google.com/schemas/sitemap-image/1.1"
+	// VideoNamespace is the XML namespace of the video sitemap extension
+	VideoNamespace = "http://www.google.com/schemas/sitemap-video/1.1"
+	// XHTMLNamespace is the XML namespace of the hreflang alternates
+	XHTMLNamespace = "http://www.w3.org/1999/xhtml"
+	// MaxImages is the max number of images of a single URL
+	MaxImages = 1000
+)
+
+const (
+	xmlHeader = xml.Header + `<urlset xmlns="` + Namespace + `" xmlns:image="` + ImageNamespace +
+		`" xmlns:video="` + VideoNamespace + `" xmlns:xhtml="` + XHTMLNamespace + `">` + "\n"
+	xmlFooter = "</urlset>\n"
+)
+
+// xmlURL is the <url> element of a URL
+type xmlURL struct {
+	XMLName    xml.Name `xml:"url"`
+	Loc        string   `xml:"loc"`
+	LastMod    string   `xml:"lastmod,omitempty"`
+	ChangeFreq string   `xml:"changefreq,omitempty"`
+	Priority   string   `xml:"priority,omitempty"`
+	Alternates []xmlLink
+	Images     []xmlImage
+	Videos     []xmlVideo
+}
+
+// xmlLink is the <xhtml:link> element of an Alternate
+type xmlLink struct {
+	XMLName  xml.Name `xml:"xhtml:link"`
+	Rel      string   `xml:"rel,attr"`
+	Hreflang string   `xml:"hreflang,attr"`
+	Href     string   `xml:"href,attr"`
+}
+
+// xmlImage is the <image:image> element of an Image
+type xmlImage struct {
+	XMLName xml.Name `xml:"image:image"`
+	Loc     string   `xml:"image:loc"`
+}
+
+// xmlVideo is the <video:video> element of a Video
+type xmlVideo struct {
+	XMLName      xml.Name `xml:"video:video"`
+	ThumbnailLoc string   `xml:"video:thumbnail_loc"`
+	Title        string   `xml:"video:title"`
+	Description  string   `xml:"video:description"`
+	ContentLoc   string   `xml:"video:content_loc"`
+}
+
+// marshalURL returns the indented <url> element of u
+func marshalURL(u URL) ([]byte, error) {
+	x := xmlURL{Loc: EscapeLoc(u.Loc), ChangeFreq: u.ChangeFreq}
+	if !u.LastMod.IsZero() {
+		x.LastMod = u.LastMod.Format(time.RFC3339)
+	}
+	if u.Priority > 0 {
+		x.Priority = fmt.Sprintf("%.1f", math.Min(1, u.Priority))
+	}
+	for _, alternate := range u.Alternates {
+		x.Alternates = append(x.Alternates, xmlLink{Rel: "alternate", Hreflang: alternate.Hreflang, Href: EscapeLoc(alternate.Href)})
+	}
+	for i, image := range u.Images {
+		if i == MaxImages {
+			break
+		}
+		x.Images = append(x.Images, xmlImage{Loc: EscapeLoc(image.Loc)})
+	}
+	for _, video := range u.Videos {
+		if video.ThumbnailLoc == "" || video.Title == "" || video.ContentLoc == "" {
+			continue
+		}
+		description := video.Description
+		if description == "" {
+			description = video.Title
+		}
+		x.Videos = append(x.Videos, xmlVideo{
+			ThumbnailLoc: EscapeLoc(video.ThumbnailLoc),
+			Title:        video.Title,
+			Description:  description,
+			ContentLoc:   EscapeLoc(video.ContentLoc),
+		})
+	}
+	entry, err := xml.MarshalIndent(x, "  ", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append(entry, '\n'), nil
+}
+
+// EscapeLoc percent-encodes the bytes of loc which aren't allowed in a
+// sitemap URL: non-ASCII characters, spaces and control characters. The
+// existing escapes are kept as is.
+// Eg: http://foo.com/café menu => http://foo.com/caf%C3%A9%20menu
+func EscapeLoc(loc string) string {
+	var b strings.Builder
+	for i := 0; i < len(loc); i++ {
+		if c := loc[i]; c <= ' ' || c >= 0x7f {
+			fmt.Fprintf(&b, "%%%02X", c)
+		} else {
+			b.WriteByte(c)
+		}
+	}
+	return b.String()
+}
+
+// Encoder writes a sitemap one URL at a time. The XML special characters
+// are escaped. Close must be called once all the URLs are written.
+type Encoder struct {
+	w       io.Writer
+	started bool // started is set once the header is written
+}
+
+// NewEncoder returns an Encoder writing to w
+func NewEncoder(w io.Writer) *Encoder {
+	return &Encoder{w: w}
+}
+
+// Encode writes the <url> entry of u
+func (e *Encoder) Encode(u URL) error {
+	entry, err := marshalURL(u)
+	if err != nil {
+		return err
+	}
+	return e.writeEntry(entry)
+}
+
+// writeEntry writes an already marshalled <url> entry
+func (e *Encoder) writeEntry(entry []byte) error {
+	if err := e.start(); err != nil {
+		return err
+	}
+	_, err := e.w.Write(entry)
+	return err
+}
+
+// start writes the header of the sitemap if it isn't written yet
+func (e *Encoder) start() error {
+	if e.started {
+		return nil
+	}
+	e.started = true
+	_, err := io.WriteString(e.w, xmlHeader)
+	return err
+}
+
+// Close writes the end of the sitemap. It doesn't close the underlying
+// writer.
+func (e *Encoder) Close() error {
+	if err := e.start(); err != nil {
+		return err
+	}
+	_, err := io.WriteString(e.w, xmlFooter)
+	return err
+}
+
+// Write writes the sitemap of urls to w. Sample sitemap
+//
+//	<?xml version="1.0" encoding="UTF-8"?>
+//	<urlset xmlns="http://www.sitemaps.org/schemas/sitemap/0.9" xmlns:image="..." xmlns:video="..." xmlns:xhtml="...">
+//	  <url>
+//	    <loc>http://foo.com/?a=1&amp;b=2</loc>
+//	    <lastmod>2020-01-02T15:04:05Z</lastmod>
+//	    <changefreq>daily</changefreq>
+//	    <priority>1.0</priority>
+//	    <xhtml:link rel="alternate" hreflang="de" href="http://foo.com/de/"></xhtml:link>
+//	    <image:image>
+//	      <image:loc>http://foo.com/logo.png</image:loc>
+//	    </image:image>
+//	  </url>
+//	</urlset>
+func Write(w io.Writer, urls []URL) error {
+	enc := NewEncoder(w)
+	for _, u := range urls {
+		if err := enc.Encode(u); err != nil {
+			return err
+		}
+	}
+	return enc.Close()
+}
+
+// changeFreqs are the values of <changefreq> allowed by the protocol
+var changeFreqs = map[string]bool{
+	"always":  true,
+	"hourly":  true,
+	"daily":   true,
+	"weekly":  true,
+	"monthly": true,
+	"yearly":  true,
+	"never":   true,
+}
+
+// ChangeFreqRule sets the <changefreq> of the URLs matching Pattern
+type ChangeFreqRule struct {
+	Pattern *regexp.Regexp
+	Freq    string
+}
+
+// ParseChangeFreqRule parses a ChangeFreqRule from a string of the form
+// "regexp=freq". Eg: "/blog/=daily"
+func ParseChangeFreqRule(s string) (ChangeFreqRule, error) {
+	i := strings.LastIndex(s, "=")
+	if i < 0 {
+		return ChangeFreqRule{}, fmt.Errorf("invalid changefreq rule %q: expected pattern=freq", s)
+	}
+	freq := strings.ToLower(strings.TrimSpace(s[i+1:]))
+	if !changeFreqs[freq] {
+		return ChangeFreqRule{}, fmt.Errorf("invalid changefreq rule %q: unknown changefreq %q", s, freq)
+	}
+	pattern, err := regexp.Compile(s[:i])
+	if err != nil {
+		return ChangeFreqRule{}, fmt.Errorf("invalid changefreq rule %q: %w", s, err)
+	}
+	return ChangeFreqRule{Pattern: pattern, Freq: freq}, nil
+}
+
+// PriorityMode selects how the <priority> of a page is derived
+type PriorityMode string
+
+const (
+	// PriorityNone omits <priority>
+	PriorityNone PriorityMode = ""
+	// PriorityDepth lowers the priority by 0.2 for every click from the
+	// base URL
+	PriorityDepth PriorityMode = "depth"
+	// PriorityInbound scales the priority with the number of pages linking
+	// to the page
+	PriorityInbound PriorityMode = "inbound"
+	// PriorityPageRank scales the priority with the PageRank of the page
+	PriorityPageRank PriorityMode = "pagerank"
+)
+
+// ParsePriorityMode parses a PriorityMode. "none" is PriorityNone.
+func ParsePriorityMode(s string) (PriorityMode, error) {
+	switch mode := PriorityMode(strings.ToLower(s)); mode {
+	case PriorityNone, PriorityDepth, PriorityInbound, PriorityPageRank:
+		return mode, nil
+	case "none":
+		return PriorityNone, nil
+	}
+	return PriorityNone, fmt.Errorf("invalid priority mode %q: expected none, depth, inbound or pagerank", s)
+}
+
+// PageStats are the stats of a page its priority is derived from
+type PageStats struct {
+	Depth       int     // Depth is the click depth of the page
+	Inbound     int     // Inbound is the number of links to the page
+	MaxInbound  int     // MaxInbound is the highest Inbound of the site
+	PageRank    float64 // PageRank is the PageRank of the page
+	MaxPageRank float64 // MaxPageRank is the highest PageRank of the site
+}
+
+// Priority returns the priority of a page with the given stats. Returns 0
+// for PriorityNone.
+func (m PriorityMode) Priority(stats PageStats) float64 {
+	var priority float64
+	switch m {
+	case PriorityDepth:
+		priority = 1 - 0.2*float64(stats.Depth)
+	case PriorityInbound:
+		if stats.MaxInbound > 0 {
+			priority = 0.1 + 0.9*float64(stats.Inbound)/float64(stats.MaxInbound)
+		}
+	case PriorityPageRank:
+		if stats.MaxPageRank > 0 {
+			priority = 0.1 + 0.9*stats.PageRank/stats.MaxPageRank
+		}
+	default:
+		return 0
+	}
+	// The protocol allows values between 0.0 and 1.0. 0.1 is used as the
+	// lowest value so that the priority isn't omitted.
+	priority = math.Round(priority*10) / 10
+	return math.Max(0.1, math.Min(1, priority))
+}
+
+// Config controls the optional fields of the sitemap entries
+type Config struct {
+	Priority   PriorityMode     // Priority selects how <priority> is derived
+	ChangeFreq []ChangeFreqRule // ChangeFreq is checked in order. The first matching rule is used
+	Images     bool             // Images adds the images of the pages
+	Videos     bool             // Videos adds the videos of the pages
+	Alternates bool             // Alternates adds the hreflang alternates of the pages
+}
+
+// ChangeFreqFor returns the <changefreq> of the first rule matching loc. Empty
+// if no rule matches.
+func (c Config) ChangeFreqFor(loc string) string {
+	for _, rule := range c.ChangeFreq {
+		if rule.Pattern.MatchString(loc) {
+			return rule.Freq
+		}
+	}
+	return ""
+}
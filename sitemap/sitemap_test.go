@@ -0,0 +1,480 @@
+package sitemap
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWrite(t *testing.T) {
+	var buf bytes.Buffer
+	urls := []URL{
+		{Loc: "http://foo.com"},
+		{
+			Loc:        "http://foo.com/blog",
+			LastMod:    time.Date(2020, 1, 2, 15, 4, 5, 0, time.UTC),
+			ChangeFreq: "daily",
+			Priority:   0.8,
+		},
+	}
+	expectedOutput := `<?xml version="1.0" encoding="UTF-8"?>
+<urlset xmlns="http://www.sitemaps.org/schemas/sitemap/0.9" xmlns:image="http://www.google.com/schemas/sitemap-image/1.1" xmlns:video="http://www.google.com/schemas/sitemap-video/1.1" xmlns:xhtml="http://www.w3.org/1999/xhtml">
+  <url>
+    <loc>http://foo.com</loc>
+  </url>
+  <url>
+    <loc>http://foo.com/blog</loc>
+    <lastmod>2020-01-02T15:04:05Z</lastmod>
+    <changefreq>daily</changefreq>
+    <priority>0.8</priority>
+  </url>
+</urlset>
+`
+	assert.Nil(t, Write(&buf, urls))
+	assert.Equal(t, expectedOutput, buf.String())
+	validateSitemap(t, buf.Bytes())
+}
+
+func TestWriteMedia(t *testing.T) {
+	var buf bytes.Buffer
+	urls := []URL{{
+		Loc:    "http://foo.com/",
+		Images: []Image{{Loc: "http://foo.com/logo.png?size=1&v=2"}},
+		Videos: []Video{
+			{ThumbnailLoc: "http://foo.com/thumb.jpg", Title: "Intro <1>", ContentLoc: "http://foo.com/intro.mp4"},
+			// Videos without a thumbnail are skipped
+			{Title: "No thumbnail", ContentLoc: "http://foo.com/other.mp4"},
+		},
+	}}
+	expectedOutput := xmlHeader + `  <url>
+    <loc>http://foo.com/</loc>
+    <image:image>
+      <image:loc>http://foo.com/logo.png?size=1&amp;v=2</image:loc>
+    </image:image>
+    <video:video>
+      <video:thumbnail_loc>http://foo.com/thumb.jpg</video:thumbnail_loc>
+      <video:title>Intro &lt;1&gt;</video:title>
+      <video:description>Intro &lt;1&gt;</video:description>
+      <video:content_loc>http://foo.com/intro.mp4</video:content_loc>
+    </video:video>
+  </url>
+` + xmlFooter
+	assert.Nil(t, Write(&buf, urls))
+	assert.Equal(t, expectedOutput, buf.String())
+	validateSitemap(t, buf.Bytes())
+
+	t.Run("max images", func(t *testing.T) {
+		u := URL{Loc: "http://foo.com/"}
+		for i := 0; i <= MaxImages; i++ {
+			u.Images = append(u.Images, Image{Loc: fmt.Sprintf("http://foo.com/%d.png", i)})
+		}
+		entry, err := marshalURL(u)
+		assert.Nil(t, err)
+		assert.Equal(t, MaxImages, bytes.Count(entry, []byte("<image:image>")))
+	})
+}
+
+func TestWriteAlternates(t *testing.T) {
+	var buf bytes.Buffer
+	urls := []URL{{
+		Loc: "http://foo.com/en/",
+		Alternates: []Alternate{
+			{Hreflang: "en", Href: "http://foo.com/en/"},
+			{Hreflang: "de", Href: "http://foo.com/de/?a=1&b=2"},
+		},
+	}}
+	expectedOutput := xmlHeader + `  <url>
+    <loc>http://foo.com/en/</loc>
+    <xhtml:link rel="alternate" hreflang="en" href="http://foo.com/en/"></xhtml:link>
+    <xhtml:link rel="alternate" hreflang="de" href="http://foo.com/de/?a=1&amp;b=2"></xhtml:link>
+  </url>
+` + xmlFooter
+	assert.Nil(t, Write(&buf, urls))
+	assert.Equal(t, expectedOutput, buf.String())
+	validateSitemap(t, buf.Bytes())
+}
+
+func TestWriteEscaping(t *testing.T) {
+	var buf bytes.Buffer
+	urls := []URL{
+		{Loc: "http://foo.com/?a=1&b=2"},
+		{Loc: `http://foo.com/<tag>"quoted"'single'`},
+		{Loc: "http://foo.com/café menu"},
+		{Loc: "http://foo.com/already%20escaped"},
+	}
+	assert.Nil(t, Write(&buf, urls))
+	assert.Contains(t, buf.String(), "<loc>http://foo.com/?a=1&amp;b=2</loc>")
+	assert.Contains(t, buf.String(), "<loc>http://foo.com/&lt;tag&gt;&#34;quoted&#34;&#39;single&#39;</loc>")
+	validateSitemap(t, buf.Bytes())
+
+	// The decoded locations are the escaped URLs
+	var urlset struct {
+		Locs []string `xml:"url>loc"`
+	}
+	assert.Nil(t, xml.Unmarshal(buf.Bytes(), &urlset))
+	assert.Equal(t, []string{
+		"http://foo.com/?a=1&b=2",
+		`http://foo.com/<tag>"quoted"'single'`,
+		"http://foo.com/caf%C3%A9%20menu",
+		"http://foo.com/already%20escaped",
+	}, urlset.Locs)
+}
+
+func TestEscapeLoc(t *testing.T) {
+	testData := []struct {
+		loc      string
+		expected string
+	}{
+		{"http://foo.com/bar", "http://foo.com/bar"},
+		{"http://foo.com/a b", "http://foo.com/a%20b"},
+		{"http://foo.com/über?q=ñ", "http://foo.com/%C3%BCber?q=%C3%B1"},
+		{"http://foo.com/%E2%82%AC", "http://foo.com/%E2%82%AC"},
+		{"http://foo.com/\ttab", "http://foo.com/%09tab"},
+	}
+	for _, tt := range testData {
+		assert.Equal(t, tt.expected, EscapeLoc(tt.loc))
+	}
+}
+
+func TestEncoder(t *testing.T) {
+	var buf bytes.Buffer
+	enc := NewEncoder(&buf)
+	assert.Nil(t, enc.Close())
+	assert.Equal(t, xmlHeader+xmlFooter, buf.String())
+	validateSitemap(t, buf.Bytes())
+}
+
+func TestParseChangeFreqRule(t *testing.T) {
+	rule, err := ParseChangeFreqRule("^https://foo.com/blog/=Daily")
+	assert.Nil(t, err)
+	assert.Equal(t, "daily", rule.Freq)
+	assert.True(t, rule.Pattern.MatchString("https://foo.com/blog/post"))
+
+	// The last "=" separates the pattern from the changefreq
+	rule, err = ParseChangeFreqRule(`\?page=\d+=hourly`)
+	assert.Nil(t, err)
+	assert.Equal(t, "hourly", rule.Freq)
+	assert.True(t, rule.Pattern.MatchString("https://foo.com/?page=2"))
+
+	for _, invalid := range []string{"daily", "/blog/=sometimes", "(=daily"} {
+		_, err := ParseChangeFreqRule(invalid)
+		assert.NotNil(t, err, invalid)
+	}
+}
+
+func TestChangeFreqFor(t *testing.T) {
+	blog, _ := ParseChangeFreqRule("/blog/=daily")
+	all, _ := ParseChangeFreqRule(".*=monthly")
+	config := Config{ChangeFreq: []ChangeFreqRule{blog, all}}
+	assert.Equal(t, "daily", config.ChangeFreqFor("http://foo.com/blog/post"))
+	assert.Equal(t, "monthly", config.ChangeFreqFor("http://foo.com/about"))
+	assert.Equal(t, "", Config{}.ChangeFreqFor("http://foo.com/about"))
+}
+
+func TestPriority(t *testing.T) {
+	testData := []struct {
+		name     string
+		mode     PriorityMode
+		stats    PageStats
+		expected float64
+	}{
+		{"none", PriorityNone, PageStats{Inbound: 5, MaxInbound: 10}, 0},
+		{"depth 0", PriorityDepth, PageStats{}, 1},
+		{"depth 2", PriorityDepth, PageStats{Depth: 2}, 0.6},
+		{"deep page", PriorityDepth, PageStats{Depth: 10}, 0.1},
+		{"most linked", PriorityInbound, PageStats{Depth: 3, Inbound: 10, MaxInbound: 10}, 1},
+		{"half linked", PriorityInbound, PageStats{Depth: 3, Inbound: 5, MaxInbound: 10}, 0.6},
+		{"not linked", PriorityInbound, PageStats{MaxInbound: 10}, 0.1},
+		{"no links", PriorityInbound, PageStats{}, 0.1},
+		{"highest pagerank", PriorityPageRank, PageStats{PageRank: 0.4, MaxPageRank: 0.4}, 1},
+		{"quarter pagerank", PriorityPageRank, PageStats{PageRank: 0.1, MaxPageRank: 0.4}, 0.3},
+		{"no pagerank", PriorityPageRank, PageStats{}, 0.1},
+	}
+	for _, tt := range testData {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.expected, tt.mode.Priority(tt.stats))
+		})
+	}
+}
+func TestParsePriorityMode(t *testing.T) {
+	for input, expected := range map[string]PriorityMode{"": PriorityNone, "none": PriorityNone, "depth": PriorityDepth, "Inbound": PriorityInbound, "pagerank": PriorityPageRank} {
+		mode, err := ParsePriorityMode(input)
+		assert.Nil(t, err)
+		assert.Equal(t, expected, mode)
+	}
+	_, err := ParsePriorityMode("random")
+	assert.NotNil(t, err)
+}
+
+func TestWriteIndex(t *testing.T) {
+	var buf bytes.Buffer
+	sitemaps := []Sitemap{
+		{Loc: "http://foo.com/sitemap-1.xml", LastMod: time.Date(2020, 1, 2, 15, 4, 5, 0, time.UTC)},
+		{Loc: "http://foo.com/sitemap-2.xml"},
+	}
+	expectedOutput := `<?xml version="1.0" encoding="UTF-8"?>
+<sitemapindex xmlns="http://www.sitemaps.org/schemas/sitemap/0.9">
+  <sitemap>
+    <loc>http://foo.com/sitemap-1.xml</loc>
+    <lastmod>2020-01-02T15:04:05Z</lastmod>
+  </sitemap>
+  <sitemap>
+    <loc>http://foo.com/sitemap-2.xml</loc>
+  </sitemap>
+</sitemapindex>
+`
+	assert.Nil(t, WriteIndex(&buf, sitemaps))
+	assert.Equal(t, expectedOutput, buf.String())
+	validateIndex(t, buf.Bytes())
+}
+
+func TestWriteFiles(t *testing.T) {
+	var urls []URL
+	for i := 0; i < 5; i++ {
+		urls = append(urls, URL{Loc: fmt.Sprintf("http://foo.com/%d", i)})
+	}
+	urls[3].LastMod = time.Date(2020, 1, 2, 15, 4, 5, 0, time.UTC)
+
+	t.Run("split by URL count", func(t *testing.T) {
+		dir := t.TempDir()
+		paths, err := WriteFiles(urls, FileOptions{Dir: dir, BaseURL: "https://cdn.foo.com/maps/", MaxURLs: 2})
+		assert.Nil(t, err)
+		assert.Equal(t, []string{
+			filepath.Join(dir, "sitemap-1.xml"),
+			filepath.Join(dir, "sitemap-2.xml"),
+			filepath.Join(dir, "sitemap-3.xml"),
+			filepath.Join(dir, IndexFileName),
+		}, paths)
+
+		for _, path := range paths[:3] {
+			validateSitemap(t, []byte(readFile(t, path)))
+		}
+		var expected bytes.Buffer
+		assert.Nil(t, Write(&expected, urls[2:4]))
+		assert.Equal(t, expected.String(), readFile(t, paths[1]))
+
+		expected.Reset()
+		assert.Nil(t, WriteIndex(&expected, []Sitemap{
+			{Loc: "https://cdn.foo.com/maps/sitemap-1.xml"},
+			{Loc: "https://cdn.foo.com/maps/sitemap-2.xml", LastMod: urls[3].LastMod},
+			{Loc: "https://cdn.foo.com/maps/sitemap-3.xml"},
+		}))
+		assert.Equal(t, expected.String(), readFile(t, paths[3]))
+		validateIndex(t, []byte(readFile(t, paths[3])))
+	})
+	t.Run("split by size", func(t *testing.T) {
+		// All the entries have the same size
+		sameSize := append([]URL{}, urls...)
+		sameSize[3].LastMod = time.Time{}
+		entry, err := marshalURL(sameSize[0])
+		assert.Nil(t, err)
+		// Room for two entries per file
+		maxSize := len(xmlHeader) + len(xmlFooter) + 2*len(entry)
+		paths, err := WriteFiles(sameSize, FileOptions{Dir: t.TempDir(), MaxSize: maxSize})
+		assert.Nil(t, err)
+		assert.Len(t, paths, 4)
+		for _, path := range paths[:3] {
+			assert.LessOrEqual(t, len(readFile(t, path)), maxSize)
+		}
+		// The files are referenced by name without a base URL
+		assert.Contains(t, readFile(t, paths[3]), "<loc>sitemap-3.xml</loc>")
+	})
+	t.Run("gzip", func(t *testing.T) {
+		dir := t.TempDir()
+		paths, err := WriteFiles(urls, FileOptions{Dir: dir, BaseURL: "https://foo.com", Gzip: true})
+		assert.Nil(t, err)
+		assert.Equal(t, []string{filepath.Join(dir, "sitemap-1.xml.gz"), filepath.Join(dir, IndexFileName)}, paths)
+
+		file, err := os.Open(paths[0])
+		assert.Nil(t, err)
+		defer file.Close()
+		gz, err := gzip.NewReader(file)
+		assert.Nil(t, err)
+		content, err := io.ReadAll(gz)
+		assert.Nil(t, err)
+		var expected bytes.Buffer
+		assert.Nil(t, Write(&expected, urls))
+		assert.Equal(t, expected.String(), string(content))
+		assert.Contains(t, readFile(t, paths[1]), "<loc>https://foo.com/sitemap-1.xml.gz</loc>")
+	})
+	t.Run("no URLs", func(t *testing.T) {
+		paths, err := WriteFiles(nil, FileOptions{Dir: filepath.Join(t.TempDir(), "new")})
+		assert.Nil(t, err)
+		assert.Len(t, paths, 2)
+		var expected bytes.Buffer
+		assert.Nil(t, Write(&expected, nil))
+		assert.Equal(t, expected.String(), readFile(t, paths[0]))
+	})
+}
+
+func TestReadLocs(t *testing.T) {
+	t.Run("sitemap", func(t *testing.T) {
+		var buf bytes.Buffer
+		assert.Nil(t, Write(&buf, []URL{
+			{Loc: "http://foo.com/?a=1&b=2", Images: []Image{{Loc: "http://foo.com/logo.png"}}},
+			{Loc: "http://foo.com/about"},
+		}))
+		locs, index, err := ReadLocs(&buf)
+		assert.Nil(t, err)
+		assert.False(t, index)
+		// <image:loc> isn't a page
+		assert.Equal(t, []string{"http://foo.com/?a=1&b=2", "http://foo.com/about"}, locs)
+	})
+	t.Run("gzipped index", func(t *testing.T) {
+		var buf bytes.Buffer
+		gz := gzip.NewWriter(&buf)
+		assert.Nil(t, WriteIndex(gz, []Sitemap{{Loc: "http://foo.com/sitemap-1.xml"}, {Loc: "http://foo.com/sitemap-2.xml"}}))
+		assert.Nil(t, gz.Close())
+		locs, index, err := ReadLocs(&buf)
+		assert.Nil(t, err)
+		assert.True(t, index)
+		assert.Equal(t, []string{"http://foo.com/sitemap-1.xml", "http://foo.com/sitemap-2.xml"}, locs)
+	})
+	t.Run("without namespace", func(t *testing.T) {
+		locs, _, err := ReadLocs(bytes.NewBufferString("<urlset><url><loc>\n  http://foo.com/\n</loc></url></urlset>"))
+		assert.Nil(t, err)
+		assert.Equal(t, []string{"http://foo.com/"}, locs)
+	})
+	t.Run("not a sitemap", func(t *testing.T) {
+		_, _, err := ReadLocs(bytes.NewBufferString("<html><body><loc>http://foo.com/</loc></body></html>"))
+		assert.Equal(t, ErrNotSitemap, err)
+		_, _, err = ReadLocs(bytes.NewBufferString(""))
+		assert.Equal(t, ErrNotSitemap, err)
+		_, _, err = ReadLocs(bytes.NewBufferString("<urlset><url><loc>"))
+		assert.Error(t, err)
+	})
+}
+
+func readFile(t *testing.T, path string) string {
+	content, err := os.ReadFile(path)
+	assert.Nil(t, err)
+	return string(content)
+}
+
+// w3cDatetimeLayouts are the formats of the W3C Datetime used by <lastmod>
+var w3cDatetimeLayouts = []string{"2006", "2006-01", "2006-01-02", "2006-01-02T15:04Z07:00", time.RFC3339, time.RFC3339Nano}
+
+// validateSitemap checks that data is valid against the sitemap schema.
+// See https://www.sitemaps.org/schemas/sitemap/0.9/sitemap.xsd
+func validateSitemap(t *testing.T, data []byte) {
+	var urlset struct {
+		XMLName xml.Name
+		URLs    []struct {
+			XMLName    xml.Name
+			Loc        []string `xml:"loc"`
+			LastMod    []string `xml:"lastmod"`
+			ChangeFreq []string `xml:"changefreq"`
+			Priority   []string `xml:"priority"`
+			Other      []struct {
+				XMLName      xml.Name
+				Loc          []string `xml:"loc"`
+				ThumbnailLoc []string `xml:"thumbnail_loc"`
+				Title        []string `xml:"title"`
+				Description  []string `xml:"description"`
+				ContentLoc   []string `xml:"content_loc"`
+				PlayerLoc    []string `xml:"player_loc"`
+			} `xml:",any"`
+		} `xml:"url"`
+	}
+	if !assert.Nil(t, xml.Unmarshal(data, &urlset), "sitemap is not well formed") {
+		return
+	}
+	assert.Equal(t, xml.Name{Space: Namespace, Local: "urlset"}, urlset.XMLName)
+	assert.LessOrEqual(t, len(urlset.URLs), MaxURLs)
+	assert.LessOrEqual(t, len(data), MaxSize)
+	for _, u := range urlset.URLs {
+		assert.Equal(t, Namespace, u.XMLName.Space)
+		if assert.Len(t, u.Loc, 1, "<url> must have a single <loc>") {
+			validateLoc(t, u.Loc[0])
+		}
+		if assert.LessOrEqual(t, len(u.LastMod), 1) && len(u.LastMod) == 1 {
+			validateDatetime(t, u.LastMod[0])
+		}
+		if assert.LessOrEqual(t, len(u.ChangeFreq), 1) && len(u.ChangeFreq) == 1 {
+			assert.True(t, changeFreqs[u.ChangeFreq[0]], "invalid <changefreq> %q", u.ChangeFreq[0])
+		}
+		if assert.LessOrEqual(t, len(u.Priority), 1) && len(u.Priority) == 1 {
+			priority, err := strconv.ParseFloat(u.Priority[0], 64)
+			assert.Nil(t, err)
+			assert.True(t, priority >= 0 && priority <= 1, "invalid <priority> %q", u.Priority[0])
+		}
+		// Only the elements of other namespaces are allowed
+		images := 0
+		for _, other := range u.Other {
+			assert.NotEqual(t, Namespace, other.XMLName.Space, "unexpected element <%s>", other.XMLName.Local)
+			switch other.XMLName {
+			case xml.Name{Space: ImageNamespace, Local: "image"}:
+				images++
+				if assert.Len(t, other.Loc, 1, "<image:image> must have a single <image:loc>") {
+					validateLoc(t, other.Loc[0])
+				}
+			case xml.Name{Space: VideoNamespace, Local: "video"}:
+				// See https://developers.google.com/search/docs/crawling-indexing/sitemaps/video-sitemaps
+				assert.Len(t, other.ThumbnailLoc, 1, "<video:video> must have a single <video:thumbnail_loc>")
+				assert.Len(t, other.Title, 1, "<video:video> must have a single <video:title>")
+				assert.Len(t, other.Description, 1, "<video:video> must have a single <video:description>")
+				assert.Equal(t, 1, len(other.ContentLoc)+len(other.PlayerLoc), "<video:video> must have a <video:content_loc> or a <video:player_loc>")
+				for _, loc := range append(other.ThumbnailLoc, other.ContentLoc...) {
+					validateLoc(t, loc)
+				}
+			}
+		}
+		assert.LessOrEqual(t, images, MaxImages)
+	}
+}
+
+// validateIndex checks that data is valid against the sitemap index schema.
+// See https://www.sitemaps.org/schemas/sitemap/0.9/siteindex.xsd
+func validateIndex(t *testing.T, data []byte) {
+	var index struct {
+		XMLName  xml.Name
+		Sitemaps []struct {
+			Loc     []string `xml:"loc"`
+			LastMod []string `xml:"lastmod"`
+		} `xml:"sitemap"`
+	}
+	if !assert.Nil(t, xml.Unmarshal(data, &index), "sitemap index is not well formed") {
+		return
+	}
+	assert.Equal(t, xml.Name{Space: Namespace, Local: "sitemapindex"}, index.XMLName)
+	assert.LessOrEqual(t, len(index.Sitemaps), MaxURLs)
+	for _, s := range index.Sitemaps {
+		if assert.Len(t, s.Loc, 1, "<sitemap> must have a single <loc>") {
+			validateLoc(t, s.Loc[0])
+		}
+		if assert.LessOrEqual(t, len(s.LastMod), 1) && len(s.LastMod) == 1 {
+			validateDatetime(t, s.LastMod[0])
+		}
+	}
+}
+
+// validateLoc checks that loc is an escaped absolute URL of 12 to 2048
+// characters
+func validateLoc(t *testing.T, loc string) {
+	assert.True(t, len(loc) >= 12 && len(loc) <= 2048, "invalid <loc> length %q", loc)
+	assert.Equal(t, EscapeLoc(loc), loc, "<loc> is not escaped")
+	u, err := url.Parse(loc)
+	if assert.Nil(t, err) {
+		assert.True(t, u.IsAbs(), "<loc> is not absolute %q", loc)
+	}
+}
+
+// validateDatetime checks that value is a W3C Datetime
+func validateDatetime(t *testing.T, value string) {
+	for _, layout := range w3cDatetimeLayouts {
+		if _, err := time.Parse(layout, value); err == nil {
+			return
+		}
+	}
+	t.Errorf("invalid W3C Datetime %q", value)
+}